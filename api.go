@@ -3,9 +3,11 @@ package itembase
 import (
 	"bytes"
 	"compress/gzip"
+	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"io"
-	"log"
 	"net/http"
 	"net/url"
 	"time"
@@ -13,18 +15,50 @@ import (
 	"github.com/facebookgo/httpcontrol"
 )
 
-// httpClient is the HTTP client used to make calls to Itembase with the default API
+// httpClient is the HTTP client used to make calls to Itembase with the default
+// API, unless Config.HTTPClient is set.
 var httpClient = newTimeoutClient(connectTimeout, readWriteTimeout)
 
 // itembaseAPI is the internal implementation of the Itembase API client.
-type itembaseAPI struct{}
+type itembaseAPI struct {
+	client  *http.Client
+	logger  Logger
+	retry   RetryPolicy
+	limiter *RateLimiter
+	breaker *CircuitBreaker
+}
+
+// newAPI returns the default API implementation configured by options.
+func newAPI(options Config) *itembaseAPI {
+	return &itembaseAPI{
+		client:  options.HTTPClient,
+		logger:  options.logger(),
+		retry:   options.retryPolicy(),
+		limiter: options.RateLimiter,
+		breaker: options.CircuitBreaker,
+	}
+}
+
+func (f *itembaseAPI) httpClient() *http.Client {
+	if f.client == nil {
+		return httpClient
+	}
+	return f.client
+}
+
+func (f *itembaseAPI) log() Logger {
+	if f.logger == nil {
+		return nopLogger{}
+	}
+	return f.logger
+}
 
 var (
 	connectTimeout   = time.Duration(120 * time.Second) // timeout for http connection
 	readWriteTimeout = time.Duration(120 * time.Second) // timeout for http read/write
 )
 
-func doItembaseRequest(client *http.Client, method, path, auth, accept string, body interface{}, params map[string]string) (*http.Response, error) {
+func doItembaseRequest(ctx context.Context, client *http.Client, method, path, auth, accept string, body interface{}, params map[string]string) (*http.Response, error) {
 
 	qs := url.Values{}
 
@@ -41,8 +75,7 @@ func doItembaseRequest(client *http.Client, method, path, auth, accept string, b
 		return nil, err
 	}
 
-	log.Println(path)
-	req, err := http.NewRequest(method, path, bytes.NewReader(encodedBody))
+	req, err := http.NewRequestWithContext(ctx, method, path, bytes.NewReader(encodedBody))
 	if err != nil {
 		return nil, err
 	}
@@ -65,12 +98,70 @@ func doItembaseRequest(client *http.Client, method, path, auth, accept string, b
 
 // Call invokes the appropriate HTTP method on a given Itembase URL.
 func (f *itembaseAPI) Call(method, path, auth string, body interface{}, params map[string]string, dest interface{}) error {
-	response, err := doItembaseRequest(httpClient, method, path, auth, "", body, params)
+	return f.CallContext(context.Background(), method, path, auth, body, params, dest)
+}
+
+// CallContext is like Call, but aborts the request when ctx is cancelled or
+// its deadline expires. Failed attempts are retried according to the
+// client's RetryPolicy.
+func (f *itembaseAPI) CallContext(ctx context.Context, method, path, auth string, body interface{}, params map[string]string, dest interface{}) error {
+	for attempt := 1; ; attempt++ {
+		err := f.callOnce(ctx, method, path, auth, body, params, dest)
+		if err == nil || attempt >= f.retry.MaxAttempts || !f.retry.retries(method, err) {
+			return err
+		}
+
+		delay, ok := f.retry.delay(attempt, err)
+		if !ok {
+			f.log().Warn("not retrying itembase request, Retry-After exceeds the limit", "method", method, "endpoint", path, "attempt", attempt, "error", err)
+			return err
+		}
+		f.log().Info("retrying itembase request", "method", method, "endpoint", path, "attempt", attempt, "delay", delay, "error", err)
+
+		if sleepErr := sleep(ctx, delay); sleepErr != nil {
+			return fmt.Errorf("%w (last error: %w)", sleepErr, err)
+		}
+	}
+}
+
+// callOnce makes a single attempt at a call, guarded by the circuit breaker.
+func (f *itembaseAPI) callOnce(ctx context.Context, method, path, auth string, body interface{}, params map[string]string, dest interface{}) (err error) {
+	if f.breaker != nil {
+		host := hostOf(path)
+		generation, rejected := f.breaker.allow(host)
+		if rejected != nil {
+			f.log().Debug("itembase request rejected", "method", method, "endpoint", path, "error", rejected)
+			return rejected
+		}
+		defer func() { f.breaker.done(host, generation, err) }()
+	}
+
+	return f.request(ctx, method, path, auth, body, params, dest)
+}
+
+// request performs a single HTTP transaction and decodes its response.
+func (f *itembaseAPI) request(ctx context.Context, method, path, auth string, body interface{}, params map[string]string, dest interface{}) error {
+	userID := UserFromContext(ctx)
+
+	if f.limiter != nil {
+		if err := f.limiter.Wait(ctx, userID); err != nil {
+			return err
+		}
+	}
+
+	f.log().Debug("itembase request", "method", method, "endpoint", path, "user", userID)
+
+	response, err := doItembaseRequest(ctx, f.httpClient(), method, path, auth, "", body, params)
 	if err != nil {
-		log.Println("Error when making Itembase Request", err)
+		err = redactURL(err)
+		f.log().Warn("itembase request failed", "method", method, "endpoint", path, "error", err)
 		return err
 	}
 
+	if f.limiter != nil {
+		f.limiter.Observe(userID, response)
+	}
+
 	defer response.Body.Close()
 
 	// Check that the server actually sent compressed data
@@ -79,7 +170,7 @@ func (f *itembaseAPI) Call(method, path, auth string, body interface{}, params m
 	case "gzip":
 		reader, err = gzip.NewReader(response.Body)
 		if err != nil {
-			log.Println("Error when decoding gzipped response body", err)
+			f.log().Warn("decoding gzipped response body failed", "endpoint", path, "error", err)
 			return err
 		}
 		defer reader.Close()
@@ -87,32 +178,69 @@ func (f *itembaseAPI) Call(method, path, auth string, body interface{}, params m
 		reader = response.Body
 	}
 
-	decoder := json.NewDecoder(reader)
 	if response.StatusCode >= 400 {
-		err := &Error{Code: response.StatusCode, Message: response.Status}
-		decoder.Decode(err)
+		err := newAPIError(method, path, response, reader)
+		f.log().Debug("itembase error response", "method", method, "endpoint", path, "status", response.StatusCode, "request_id", err.RequestID)
 		return err
 	}
 
 	if dest != nil && response.ContentLength != 0 {
-		err = decoder.Decode(dest)
+		err = json.NewDecoder(reader).Decode(dest)
 		if err != nil {
-			log.Println("Error when decoding body", err)
-			return err
+			f.log().Warn("decoding response body failed", "endpoint", path, "error", err)
+			return fmt.Errorf("%w: %s %s: %w", ErrDecode, method, path, err)
 		}
 	}
 
 	return nil
 }
 
+// redactURL removes the query, which may carry filters and other parameters,
+// from the URL of a transport error, so the error can be logged and returned.
+func redactURL(err error) error {
+	var urlErr *url.Error
+	if !errors.As(err, &urlErr) {
+		return err
+	}
+
+	if u, parseErr := url.Parse(urlErr.URL); parseErr == nil {
+		u.RawQuery, u.Fragment = "", ""
+		urlErr.URL = u.String()
+	} else {
+		urlErr.URL = ""
+	}
+	return err
+}
+
+// maxErrorBody is the maximum number of bytes of an error response kept in
+// APIError.Body.
+const maxErrorBody = 64 << 10
+
+// newAPIError builds the APIError for an error response, decoding the
+// itembase error message from body when possible.
+func newAPIError(method, path string, response *http.Response, body io.Reader) *APIError {
+	err := &APIError{
+		Message:    http.StatusText(response.StatusCode),
+		Code:       response.StatusCode,
+		StatusCode: response.StatusCode,
+		Method:     method,
+		Endpoint:   path,
+		RequestID:  response.Header.Get("X-Request-Id"),
+		RetryAfter: parseRetryAfter(response.Header.Get("Retry-After"), time.Now()),
+	}
+
+	err.Body, _ = io.ReadAll(io.LimitReader(body, maxErrorBody))
+	json.Unmarshal(err.Body, err)
+
+	return err
+}
+
 func newTimeoutClient(connectTimeout time.Duration, readWriteTimeout time.Duration) *http.Client {
 	return &http.Client{
 		Transport: &httpcontrol.Transport{
 			RequestTimeout:      readWriteTimeout,
 			DialTimeout:         connectTimeout,
-			MaxTries:            60,
 			MaxIdleConnsPerHost: 5,
-			RetryAfterTimeout:   true,
 		},
 	}
 }
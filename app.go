@@ -0,0 +1,107 @@
+package itembase
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/clientcredentials"
+)
+
+// AppTokenKey is the user ID under which the application token of the client
+// credentials grant is passed to the TokenLoader and TokenSaver. It cannot
+// clash with itembase user IDs, which are UUIDs, and is left out by the
+// TokenLister of the built-in stores.
+const AppTokenKey = "itembase:app"
+
+// App returns a client authenticated as the application itself, through the
+// client credentials grant, for app level endpoints such as Activate. If no
+// application token can be obtained, every call made through the returned
+// client fails with the corresponding error; use ForApp to handle it right
+// away.
+func (c *client) App() Client {
+	d, err := c.forApp(context.Background())
+	d.err = err
+	return d
+}
+
+// ForApp is like App, but obtains the application token with ctx and returns
+// its error.
+func (c *client) ForApp(ctx context.Context) (Client, error) {
+	d, err := c.forApp(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return d, nil
+}
+
+func (c *client) forApp(ctx context.Context) (*client, error) {
+	d := c.clone()
+	d.auth = ""
+	d.user = ""
+	d.params = make(map[string]string)
+	d.url = d.root
+	d.max = 0
+	d.err = nil
+	d.granted = nil
+	d.scope = ""
+
+	token, err := d.getAppToken(ctx)
+	if err != nil {
+		return d, err
+	}
+	d.auth = token.AccessToken
+	d.granted = grantedScopes(token)
+
+	return d, nil
+}
+
+// appToken caches the application token of a client and of the clients
+// derived from it.
+type appToken struct {
+	mu    sync.Mutex
+	token *oauth2.Token
+}
+
+// getAppToken returns the cached application token or the one stored through
+// the TokenLoader, or a new one if both are missing or expired. New tokens are
+// passed to the TokenSaver.
+func (c *client) getAppToken(ctx context.Context) (*oauth2.Token, error) {
+	c.app.mu.Lock()
+	defer c.app.mu.Unlock()
+
+	if c.app.token.Valid() {
+		return c.app.token, nil
+	}
+
+	cached, err := c.GetCachedToken(AppTokenKey)
+	if err != nil && !errors.Is(err, ErrNoTokenCache) {
+		c.options.logger().Warn("loading application token failed", "error", err)
+	}
+	if err == nil && cached.Valid() {
+		c.app.token = cached
+		return cached, nil
+	}
+
+	config := &clientcredentials.Config{
+		ClientID:     c.options.ClientID,
+		ClientSecret: c.options.ClientSecret,
+		TokenURL:     c.endpoints.TokenURL,
+		Scopes:       c.options.AppScopes,
+	}
+	token, err := config.Token(c.oauthContext(ctx))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrTokenExchange, err)
+	}
+
+	if saver := c.options.TokenHandler.TokenSaver; saver != nil {
+		if err := saver(AppTokenKey, token); err != nil {
+			return nil, fmt.Errorf("%w: %w", ErrTokenSave, err)
+		}
+	}
+	c.app.token = token
+
+	return token, nil
+}
@@ -0,0 +1,98 @@
+package itembase_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"gopkg.in/saasbuilders/itembase.v0"
+	"gopkg.in/saasbuilders/itembase.v0/itembasetest"
+)
+
+// countingTransport counts the requests made to token endpoints.
+type countingTransport struct {
+	http.RoundTripper
+	tokenRequests int32
+}
+
+func (t *countingTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	if strings.HasSuffix(r.URL.Path, "/token") {
+		atomic.AddInt32(&t.tokenRequests, 1)
+	}
+	return t.RoundTripper.RoundTrip(r)
+}
+
+func newAppConfig(t *testing.T) (*itembasetest.Server, itembase.Config, *countingTransport) {
+	t.Helper()
+
+	server := itembasetest.NewServer()
+	t.Cleanup(server.Close)
+
+	transport := &countingTransport{RoundTripper: server.Client().Transport}
+	config := server.Config()
+	config.HTTPClient = &http.Client{Transport: transport}
+	config.ClientCredentials = true
+	config.AppScopes = []string{"solution.activate"}
+	return server, config, transport
+}
+
+func TestAppTokenCached(t *testing.T) {
+	server, config, transport := newAppConfig(t)
+	config.TokenHandler = itembase.ItembaseTokens{}
+
+	root := itembase.New(config, nil)
+	for i := 0; i < 3; i++ {
+		if _, err := root.Activate(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := root.App().Activate(); err != nil {
+		t.Fatal(err)
+	}
+
+	if server.Activations() != 4 {
+		t.Errorf("%d activations, want 4", server.Activations())
+	}
+	if n := atomic.LoadInt32(&transport.tokenRequests); n != 1 {
+		t.Errorf("%d token requests, want 1", n)
+	}
+}
+
+func TestAppTokenStored(t *testing.T) {
+	_, config, transport := newAppConfig(t)
+	store, err := itembase.NewFileTokenStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	config.TokenHandler = store.Handler()
+
+	if _, err := itembase.New(config, nil).Activate(); err != nil {
+		t.Fatal(err)
+	}
+	// a new client starts from the stored token
+	if _, err := itembase.New(config, nil).ForApp(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if n := atomic.LoadInt32(&transport.tokenRequests); n != 1 {
+		t.Errorf("%d token requests, want 1", n)
+	}
+
+	if token, err := store.Load(itembase.AppTokenKey); err != nil || token == nil {
+		t.Errorf("Load(AppTokenKey) = %v, %v", token, err)
+	}
+	if userIDs, err := store.List(); err != nil || len(userIDs) != 0 {
+		t.Errorf("List() = %v, %v, want no users", userIDs, err)
+	}
+}
+
+func TestAppTokenExchangeFailure(t *testing.T) {
+	_, config, _ := newAppConfig(t)
+	config.ClientSecret = "wrong"
+
+	if _, err := itembase.New(config, nil).Activate(); !errors.Is(err, itembase.ErrTokenExchange) {
+		t.Errorf("err = %v, want ErrTokenExchange", err)
+	}
+}
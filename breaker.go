@@ -0,0 +1,266 @@
+package itembase
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"sync"
+	"time"
+)
+
+// ErrCircuitOpen is wrapped by errors returned without contacting itembase
+// because the circuit breaker for the endpoint's host is open.
+var ErrCircuitOpen = errors.New("itembase: circuit breaker open")
+
+// CircuitState is the state of the circuit breaker for one host.
+type CircuitState int
+
+const (
+	// CircuitClosed lets all requests through while counting failures.
+	CircuitClosed CircuitState = iota
+	// CircuitOpen rejects all requests with ErrCircuitOpen.
+	CircuitOpen
+	// CircuitHalfOpen lets a few trial requests through to decide whether
+	// to close the circuit again.
+	CircuitHalfOpen
+)
+
+func (state CircuitState) String() string {
+	switch state {
+	case CircuitClosed:
+		return "closed"
+	case CircuitOpen:
+		return "open"
+	case CircuitHalfOpen:
+		return "half-open"
+	}
+	return fmt.Sprintf("CircuitState(%d)", int(state))
+}
+
+// CircuitBreakerSettings configures a CircuitBreaker. Zero values are replaced
+// by the defaults noted on each field.
+type CircuitBreakerSettings struct {
+	// FailureRate is the fraction of failed requests within Window that
+	// opens the circuit. Defaults to 0.5.
+	FailureRate float64
+
+	// MinRequests is the number of requests within Window needed before the
+	// failure rate is considered. Defaults to 10.
+	MinRequests int
+
+	// Window is the period over which requests and failures are counted.
+	// Defaults to one minute.
+	Window time.Duration
+
+	// OpenTimeout is how long the circuit stays open before trial requests
+	// are let through. Defaults to 30 seconds.
+	OpenTimeout time.Duration
+
+	// HalfOpenRequests is the number of trial requests allowed while the
+	// circuit is half-open. Defaults to 1.
+	HalfOpenRequests int
+
+	// IsFailure reports whether a request error counts as a failure of the
+	// host. Defaults to retryable errors other than rate limiting: server
+	// errors, timeouts and dropped connections.
+	IsFailure func(err error) bool
+
+	// OnStateChange, if set, is called whenever the circuit of a host
+	// changes state.
+	OnStateChange func(host string, from, to CircuitState)
+}
+
+// A CircuitBreaker tracks the health of each itembase host and makes calls
+// fail fast with ErrCircuitOpen while a host is degraded. Share a single
+// CircuitBreaker between all clients of an application by setting it in their
+// Config.
+type CircuitBreaker struct {
+	settings CircuitBreakerSettings
+
+	mu    sync.Mutex
+	hosts map[string]*circuit
+
+	// changes are the state changes made while mu was held, to be passed
+	// to OnStateChange once it is released.
+	changes []stateChange
+}
+
+type stateChange struct {
+	host     string
+	from, to CircuitState
+}
+
+// circuit is the breaker state of a single host.
+type circuit struct {
+	state       CircuitState
+	windowStart time.Time
+	requests    int
+	failures    int
+	openedAt    time.Time
+	trials      int
+
+	// generation counts the state changes of the circuit, so outcomes of
+	// requests allowed in an earlier state are not taken for the current one.
+	generation uint64
+}
+
+// NewCircuitBreaker returns a CircuitBreaker using settings.
+func NewCircuitBreaker(settings CircuitBreakerSettings) *CircuitBreaker {
+	if settings.FailureRate <= 0 {
+		settings.FailureRate = 0.5
+	}
+	if settings.MinRequests <= 0 {
+		settings.MinRequests = 10
+	}
+	if settings.Window <= 0 {
+		settings.Window = time.Minute
+	}
+	if settings.OpenTimeout <= 0 {
+		settings.OpenTimeout = 30 * time.Second
+	}
+	if settings.HalfOpenRequests <= 0 {
+		settings.HalfOpenRequests = 1
+	}
+	if settings.IsFailure == nil {
+		settings.IsFailure = func(err error) bool {
+			return IsRetryable(err) && !errors.Is(err, ErrRateLimited)
+		}
+	}
+
+	return &CircuitBreaker{settings: settings, hosts: make(map[string]*circuit)}
+}
+
+// State returns the current state of the circuit for host.
+func (b *CircuitBreaker) State(host string) CircuitState {
+	b.mu.Lock()
+	defer b.unlock()
+
+	if c, ok := b.hosts[host]; ok {
+		b.expire(host, c, time.Now())
+		return c.state
+	}
+	return CircuitClosed
+}
+
+// allow reports whether a request to host may be made, returning an error
+// wrapping ErrCircuitOpen if not. Every allowed request must be followed by a
+// call to done with the returned generation.
+func (b *CircuitBreaker) allow(host string) (generation uint64, err error) {
+	b.mu.Lock()
+	defer b.unlock()
+
+	c, ok := b.hosts[host]
+	if !ok {
+		c = &circuit{windowStart: time.Now()}
+		b.hosts[host] = c
+	}
+
+	b.expire(host, c, time.Now())
+
+	switch c.state {
+	case CircuitOpen:
+		return 0, fmt.Errorf("%w: %s", ErrCircuitOpen, host)
+	case CircuitHalfOpen:
+		if c.trials >= b.settings.HalfOpenRequests {
+			return 0, fmt.Errorf("%w: %s", ErrCircuitOpen, host)
+		}
+		c.trials++
+	}
+
+	return c.generation, nil
+}
+
+// done records the outcome of a request to host allowed by allow in the given
+// generation. Outcomes of requests allowed before the last state change are
+// ignored, and so are cancelled requests, which say nothing about the host.
+func (b *CircuitBreaker) done(host string, generation uint64, err error) {
+	failed := err != nil && b.settings.IsFailure(err)
+	cancelled := errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded)
+
+	b.mu.Lock()
+	defer b.unlock()
+
+	c := b.hosts[host]
+	if c.generation != generation {
+		return
+	}
+	now := time.Now()
+
+	switch c.state {
+	case CircuitHalfOpen:
+		switch {
+		case failed:
+			b.transition(host, c, CircuitOpen, now)
+		case err == nil:
+			b.transition(host, c, CircuitClosed, now)
+		default:
+			// neither a success nor a failure: give the trial back
+			c.trials--
+		}
+	case CircuitClosed:
+		if cancelled && !failed {
+			return
+		}
+		if now.Sub(c.windowStart) > b.settings.Window {
+			c.windowStart, c.requests, c.failures = now, 0, 0
+		}
+		c.requests++
+		if failed {
+			c.failures++
+		}
+		if c.requests >= b.settings.MinRequests && float64(c.failures) >= b.settings.FailureRate*float64(c.requests) {
+			b.transition(host, c, CircuitOpen, now)
+		}
+	}
+}
+
+// expire moves an open circuit whose timeout has passed to half-open. It must
+// be called with b.mu held.
+func (b *CircuitBreaker) expire(host string, c *circuit, now time.Time) {
+	if c.state == CircuitOpen && now.Sub(c.openedAt) >= b.settings.OpenTimeout {
+		b.transition(host, c, CircuitHalfOpen, now)
+	}
+}
+
+// transition changes the state of a circuit, queueing a notification for
+// OnStateChange. It must be called with b.mu held.
+func (b *CircuitBreaker) transition(host string, c *circuit, to CircuitState, now time.Time) {
+	from := c.state
+	if from == to {
+		return
+	}
+
+	c.state = to
+	c.generation++
+	c.trials = 0
+	c.windowStart, c.requests, c.failures = now, 0, 0
+	if to == CircuitOpen {
+		c.openedAt = now
+	}
+
+	b.changes = append(b.changes, stateChange{host, from, to})
+}
+
+// unlock releases b.mu and reports the state changes made while it was held.
+func (b *CircuitBreaker) unlock() {
+	changes := b.changes
+	b.changes = nil
+	b.mu.Unlock()
+
+	if b.settings.OnStateChange == nil {
+		return
+	}
+	for _, change := range changes {
+		b.settings.OnStateChange(change.host, change.from, change.to)
+	}
+}
+
+// hostOf returns the host of an endpoint URL, used as circuit breaker key.
+func hostOf(endpoint string) string {
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return endpoint
+	}
+	return u.Host
+}
@@ -0,0 +1,142 @@
+package itembase
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+const testHost = "api.itembase.io"
+
+var errUnavailable = &APIError{StatusCode: http.StatusServiceUnavailable}
+
+// newTestBreaker returns a breaker opening after two failed requests, and the
+// state changes it reported so far.
+func newTestBreaker() (*CircuitBreaker, func() []string) {
+	var mu sync.Mutex
+	var changes []string
+
+	breaker := NewCircuitBreaker(CircuitBreakerSettings{
+		MinRequests: 2,
+		OpenTimeout: 20 * time.Millisecond,
+		OnStateChange: func(host string, from, to CircuitState) {
+			mu.Lock()
+			defer mu.Unlock()
+			changes = append(changes, fmt.Sprintf("%s %s>%s", host, from, to))
+		},
+	})
+	return breaker, func() []string {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]string(nil), changes...)
+	}
+}
+
+// call records a request to testHost with the given outcome.
+func call(t *testing.T, breaker *CircuitBreaker, err error) {
+	t.Helper()
+
+	generation, rejected := breaker.allow(testHost)
+	if rejected != nil {
+		t.Fatalf("allow() = %v in state %s", rejected, breaker.State(testHost))
+	}
+	breaker.done(testHost, generation, err)
+}
+
+// open makes the circuit of testHost open, and waits until it is half-open.
+func open(t *testing.T, breaker *CircuitBreaker) {
+	t.Helper()
+
+	call(t, breaker, errUnavailable)
+	call(t, breaker, errUnavailable)
+	if state := breaker.State(testHost); state != CircuitOpen {
+		t.Fatalf("state = %s, want open", state)
+	}
+	if _, err := breaker.allow(testHost); err == nil {
+		t.Fatal("allow() = nil while open")
+	}
+
+	time.Sleep(30 * time.Millisecond)
+	if state := breaker.State(testHost); state != CircuitHalfOpen {
+		t.Fatalf("state = %s, want half-open", state)
+	}
+}
+
+func TestCircuitBreaker(t *testing.T) {
+	breaker, changes := newTestBreaker()
+
+	// successes and rate limiting keep the circuit closed
+	call(t, breaker, nil)
+	call(t, breaker, &APIError{StatusCode: http.StatusTooManyRequests})
+	if state := breaker.State(testHost); state != CircuitClosed {
+		t.Fatalf("state = %s, want closed", state)
+	}
+
+	open(t, breaker)
+
+	// a single trial request is let through, and fails
+	generation, err := breaker.allow(testHost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := breaker.allow(testHost); err == nil {
+		t.Error("second trial request allowed")
+	}
+	breaker.done(testHost, generation, errUnavailable)
+	if state := breaker.State(testHost); state != CircuitOpen {
+		t.Fatalf("state = %s, want open after failed trial", state)
+	}
+
+	time.Sleep(30 * time.Millisecond)
+	call(t, breaker, nil)
+	if state := breaker.State(testHost); state != CircuitClosed {
+		t.Fatalf("state = %s, want closed after successful trial", state)
+	}
+
+	want := []string{
+		testHost + " closed>open",
+		testHost + " open>half-open",
+		testHost + " half-open>open",
+		testHost + " open>half-open",
+		testHost + " half-open>closed",
+	}
+	if got := changes(); !reflect.DeepEqual(got, want) {
+		t.Errorf("state changes = %q, want %q", got, want)
+	}
+}
+
+func TestCircuitBreakerHalfOpenNeutral(t *testing.T) {
+	for _, err := range []error{
+		context.Canceled,
+		fmt.Errorf("waiting: %w", context.DeadlineExceeded),
+		&APIError{StatusCode: http.StatusTooManyRequests},
+	} {
+		t.Run(err.Error(), func(t *testing.T) {
+			breaker, _ := newTestBreaker()
+
+			// a request allowed while closed, finishing while half-open
+			early, _ := breaker.allow(testHost)
+			open(t, breaker)
+			breaker.done(testHost, early, nil)
+			if state := breaker.State(testHost); state != CircuitHalfOpen {
+				t.Fatalf("state = %s after earlier request, want half-open", state)
+			}
+
+			// the trial request neither succeeds nor fails
+			call(t, breaker, err)
+			if state := breaker.State(testHost); state != CircuitHalfOpen {
+				t.Fatalf("state = %s, want half-open", state)
+			}
+
+			// and its slot is given back to the next trial
+			call(t, breaker, nil)
+			if state := breaker.State(testHost); state != CircuitClosed {
+				t.Errorf("state = %s, want closed", state)
+			}
+		})
+	}
+}
@@ -0,0 +1,362 @@
+package itembase
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+
+	"golang.org/x/oauth2"
+)
+
+// ErrNoInteraction is wrapped by errors returned by a ReplayAPI for calls that
+// were not recorded in its cassette.
+var ErrNoInteraction = errors.New("itembase: no recorded interaction")
+
+// redacted replaces sensitive values in cassettes.
+const redacted = "REDACTED"
+
+// sensitiveFields are the JSON field names whose values are scrubbed from
+// cassettes.
+var sensitiveFields = map[string]bool{
+	"access_token":  true,
+	"refresh_token": true,
+	"id_token":      true,
+	"token":         true,
+	"client_secret": true,
+	"password":      true,
+	"authorization": true,
+}
+
+// sensitiveParams are the request parameter names whose values are scrubbed
+// from cassettes.
+var sensitiveParams = map[string]bool{
+	"access_token":  true,
+	"refresh_token": true,
+	"id_token":      true,
+	"token":         true,
+	"client_secret": true,
+	"code":          true,
+	"code_verifier": true,
+	"password":      true,
+}
+
+// An Interaction is a single API call recorded in a Cassette. The auth token
+// of the call is never recorded, and sensitive parameters and response fields
+// are scrubbed.
+type Interaction struct {
+	Method string `json:"method"`
+
+	// Path is the path of the called URL. The scheme and host are not part
+	// of it, so cassettes recorded against a local stand-in replay
+	// regardless of its address.
+	Path   string            `json:"path"`
+	Params map[string]string `json:"params,omitempty"`
+
+	// Status is the HTTP status of the response, 0 if the call failed
+	// without one.
+	Status   int             `json:"status"`
+	Response json.RawMessage `json:"response,omitempty"`
+
+	// Error is the message of a call that failed without a response.
+	Error string `json:"error,omitempty"`
+}
+
+// A Cassette is a sequence of recorded interactions, stored as JSON.
+type Cassette struct {
+	Interactions []Interaction `json:"interactions"`
+}
+
+// LoadCassette reads the cassette stored in the named file.
+func LoadCassette(name string) (*Cassette, error) {
+	data, err := os.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+
+	cassette := new(Cassette)
+	if err := json.Unmarshal(data, cassette); err != nil {
+		return nil, fmt.Errorf("loading cassette %s: %w", name, err)
+	}
+	return cassette, nil
+}
+
+// Save writes the cassette to the named file.
+func (cassette *Cassette) Save(name string) error {
+	data, err := json.MarshalIndent(cassette, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(name, append(data, '\n'), 0644)
+}
+
+// A RecordingAPI wraps an API and records every call made through it, for
+// later replay with a ReplayAPI.
+//
+//	recorder := itembase.NewRecordingAPI(config, nil)
+//	storeRef := itembase.New(config, recorder).User(userID)
+//	// ... make calls ...
+//	err := recorder.Save("testdata/sync.json")
+type RecordingAPI struct {
+	api API
+
+	mu       sync.Mutex
+	cassette Cassette
+}
+
+// NewRecordingAPI returns a RecordingAPI wrapping api or, if api is nil, the
+// default API implementation configured by config, with its HTTPClient,
+// Logger, RetryPolicy, RateLimiter and CircuitBreaker.
+func NewRecordingAPI(config Config, api API) *RecordingAPI {
+	if api == nil {
+		api = newAPI(config)
+	}
+	return &RecordingAPI{api: api}
+}
+
+func (r *RecordingAPI) Call(method, path, auth string, body interface{}, params map[string]string, dest interface{}) error {
+	return r.CallContext(context.Background(), method, path, auth, body, params, dest)
+}
+
+func (r *RecordingAPI) CallContext(ctx context.Context, method, path, auth string, body interface{}, params map[string]string, dest interface{}) error {
+	var response json.RawMessage
+	err := CallAPI(ctx, r.api, method, path, auth, body, params, &response)
+
+	interaction := Interaction{
+		Method: method,
+		Path:   pathOf(path),
+		Params: scrubParams(params),
+	}
+
+	var apiErr *APIError
+	switch {
+	case err == nil:
+		interaction.Status = http.StatusOK
+		interaction.Response = scrubJSON(response)
+	case errors.As(err, &apiErr):
+		interaction.Status = apiErr.StatusCode
+		interaction.Response = scrubJSON(apiErr.Body)
+	default:
+		interaction.Error = err.Error()
+	}
+
+	r.mu.Lock()
+	r.cassette.Interactions = append(r.cassette.Interactions, interaction)
+	r.mu.Unlock()
+
+	if err != nil {
+		return err
+	}
+	if dest != nil && len(response) > 0 {
+		return json.Unmarshal(response, dest)
+	}
+	return nil
+}
+
+// Cassette returns a copy of the interactions recorded so far.
+func (r *RecordingAPI) Cassette() *Cassette {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return &Cassette{Interactions: append([]Interaction(nil), r.cassette.Interactions...)}
+}
+
+// Save writes the interactions recorded so far to the named file.
+func (r *RecordingAPI) Save(name string) error {
+	return r.Cassette().Save(name)
+}
+
+// A ReplayAPI serves calls from the interactions of a Cassette, without any
+// network access. A call is answered by the first unused interaction with the
+// same method, path and parameters; once all matching interactions have been
+// used, the last one is served again.
+//
+// Token exchanges do not go through the API: set Config.TokenHandler to
+// ReplayTokens so clients for users are built without loading or refreshing
+// a token.
+//
+//	config.TokenHandler = itembase.ReplayTokens()
+//	storeRef := itembase.New(config, itembase.NewReplayAPI(cassette)).User(userID)
+type ReplayAPI struct {
+	mu           sync.Mutex
+	interactions []Interaction
+	used         []bool
+}
+
+// NewReplayAPI returns a ReplayAPI serving the interactions of cassette.
+func NewReplayAPI(cassette *Cassette) *ReplayAPI {
+	return &ReplayAPI{
+		interactions: cassette.Interactions,
+		used:         make([]bool, len(cassette.Interactions)),
+	}
+}
+
+// ReplayTokens returns token handlers for replaying cassettes. Every user,
+// and the application, has a placeholder token that never expires, so no
+// token is ever loaded, refreshed or requested; tokens are not recorded in
+// cassettes anyway.
+func ReplayTokens() ItembaseTokens {
+	return ItembaseTokens{
+		TokenLoader: func(userID string) (*oauth2.Token, error) {
+			return &oauth2.Token{AccessToken: redacted, TokenType: "Bearer"}, nil
+		},
+		TokenSaver: func(userID string, token *oauth2.Token) error {
+			return nil
+		},
+	}
+}
+
+func (r *ReplayAPI) Call(method, path, auth string, body interface{}, params map[string]string, dest interface{}) error {
+	return r.CallContext(context.Background(), method, path, auth, body, params, dest)
+}
+
+func (r *ReplayAPI) CallContext(ctx context.Context, method, path, auth string, body interface{}, params map[string]string, dest interface{}) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	interaction, ok := r.match(method, pathOf(path), scrubParams(params))
+	if !ok {
+		return fmt.Errorf("%w for %s %s %v", ErrNoInteraction, method, pathOf(path), scrubParams(params))
+	}
+
+	switch {
+	case interaction.Error != "":
+		return errors.New(interaction.Error)
+	case interaction.Status >= 400:
+		err := &APIError{
+			Message:    http.StatusText(interaction.Status),
+			Code:       interaction.Status,
+			StatusCode: interaction.Status,
+			Method:     method,
+			Endpoint:   path,
+			Body:       interaction.Response,
+		}
+		json.Unmarshal(interaction.Response, err)
+		return err
+	}
+
+	if dest != nil && len(interaction.Response) > 0 {
+		if err := json.Unmarshal(interaction.Response, dest); err != nil {
+			return fmt.Errorf("%w: %s %s: %w", ErrDecode, method, path, err)
+		}
+	}
+	return nil
+}
+
+func (r *ReplayAPI) match(method, path string, params map[string]string) (Interaction, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	last := -1
+	for i, interaction := range r.interactions {
+		if interaction.Method != method || interaction.Path != path || !sameParams(interaction.Params, params) {
+			continue
+		}
+		if !r.used[i] {
+			r.used[i] = true
+			return interaction, true
+		}
+		last = i
+	}
+
+	if last < 0 {
+		return Interaction{}, false
+	}
+	return r.interactions[last], true
+}
+
+func sameParams(a, b map[string]string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for key, value := range a {
+		if other, ok := b[key]; !ok || other != value {
+			return false
+		}
+	}
+	return true
+}
+
+// pathOf returns the path of an endpoint URL, with sensitive query parameters
+// scrubbed.
+func pathOf(endpoint string) string {
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return endpoint
+	}
+
+	path := u.EscapedPath()
+	if u.RawQuery != "" {
+		query := u.Query()
+		for key := range query {
+			if sensitiveParams[strings.ToLower(key)] {
+				query.Set(key, redacted)
+			}
+		}
+		path += "?" + query.Encode()
+	}
+	return path
+}
+
+func scrubParams(params map[string]string) map[string]string {
+	if len(params) == 0 {
+		return nil
+	}
+
+	scrubbed := make(map[string]string, len(params))
+	for key, value := range params {
+		if sensitiveParams[strings.ToLower(key)] {
+			value = redacted
+		}
+		scrubbed[key] = value
+	}
+	return scrubbed
+}
+
+// scrubJSON returns data with the values of sensitive fields replaced at any
+// depth. Data that is not valid JSON is recorded as a JSON string.
+func scrubJSON(data []byte) json.RawMessage {
+	if len(data) == 0 {
+		return nil
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+
+	var value interface{}
+	if err := decoder.Decode(&value); err != nil {
+		quoted, _ := json.Marshal(string(data))
+		return quoted
+	}
+
+	scrubbed, err := json.Marshal(scrubValue(value))
+	if err != nil {
+		return nil
+	}
+	return scrubbed
+}
+
+func scrubValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for key, field := range v {
+			if sensitiveFields[strings.ToLower(key)] {
+				v[key] = redacted
+			} else {
+				v[key] = scrubValue(field)
+			}
+		}
+	case []interface{}:
+		for i := range v {
+			v[i] = scrubValue(v[i])
+		}
+	}
+	return value
+}
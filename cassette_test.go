@@ -0,0 +1,80 @@
+package itembase_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"gopkg.in/saasbuilders/itembase.v0"
+	"gopkg.in/saasbuilders/itembase.v0/itembasetest"
+)
+
+func TestCassetteRecordReplay(t *testing.T) {
+	var fixture itembasetest.Fixture
+	for i := 0; i < 10; i++ {
+		fixture.Products = append(fixture.Products, itembase.Product{ID: itembase.ProductID(fmt.Sprintf("p%d", i))})
+	}
+
+	server := itembasetest.NewServer()
+	fixture.User.UUID = testUserID
+	if err := server.Seed(fixture); err != nil {
+		t.Fatal(err)
+	}
+	config := server.Config()
+
+	recorder := itembase.NewRecordingAPI(config, nil)
+	storeRef, err := itembase.New(config, recorder).ForUser(context.Background(), testUserID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var recorded itembase.Products
+	if err := storeRef.Products().Limit(3).GetAllInto(&recorded); err != nil {
+		t.Fatal(err)
+	}
+
+	name := filepath.Join(t.TempDir(), "products.json")
+	if err := recorder.Save(name); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	token, err := config.TokenHandler.TokenLoader(testUserID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(data, []byte(token.AccessToken)) {
+		t.Error("cassette contains the access token")
+	}
+
+	// replay offline, with a token store that must not be used
+	server.Close()
+	cassette, err := itembase.LoadCassette(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config.TokenHandler = itembase.ReplayTokens()
+
+	replayRef, err := itembase.New(config, itembase.NewReplayAPI(cassette)).ForUser(context.Background(), testUserID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var replayed itembase.Products
+	if err := replayRef.Products().Limit(3).GetAllInto(&replayed); err != nil {
+		t.Fatal(err)
+	}
+	if replayed.Count() != recorded.Count() || replayed.Count() != 10 {
+		t.Errorf("replayed %d products, recorded %d", replayed.Count(), recorded.Count())
+	}
+
+	if err := replayRef.Products().Limit(4).GetAllInto(&replayed); !errors.Is(err, itembase.ErrNoInteraction) {
+		t.Errorf("unrecorded call: err = %v, want ErrNoInteraction", err)
+	}
+}
@@ -2,30 +2,23 @@
 package itembase
 
 import (
+	"context"
 	"encoding/json"
 	"time"
-
-	log "github.com/inconshreveable/log15"
 )
 
-// Error is a Go representation of the error message sent back by itembase when a
-// request results in an error.
-type Error struct {
-	Message string `json:"message"`
-	Code    int    `json:"code"`
-}
-
-func (f *Error) Error() string {
-	return f.Message
-}
-
-// This is the default implementation
+// This is the default implementation. A client is never modified once it has
+// been handed out: every builder method returns a derived copy, so a single
+// per-user root can safely be shared between goroutines and queries.
 type client struct {
 	// root is the client's base URL used for all calls.
 	root       string
 	me         string
 	activation string
 
+	// endpoints are the resolved itembase URLs
+	endpoints Endpoints
+
 	// url is the current url to call
 	url string
 
@@ -49,6 +42,20 @@ type client struct {
 	// maximum results to be returned
 	// default 0 = no maximum results set
 	max int
+
+	// err is returned by every call made through the client. It is set when
+	// the client could not be authorized for its user.
+	err error
+
+	// granted are the scopes granted with the user's token, nil if unknown
+	granted map[string]bool
+
+	// scope is the scope needed by the client's endpoint
+	scope string
+
+	// app caches the application token; it is shared by all clients
+	// derived from the same root
+	app *appToken
 }
 
 // New creates a new instance of the default itembase Client implementation.
@@ -60,45 +67,132 @@ type client struct {
 // needed for testing.
 func New(options Config, api API) Client {
 	if api == nil {
-		api = new(itembaseAPI)
+		api = newAPI(options)
 	}
+	api = chain(api, options.Middleware)
+
+	newClient := &client{options: options, production: options.Production, api: api, app: new(appToken)}
+	newClient.setEndpoints()
 
-	return &client{options: options, production: options.Production, api: api}
+	return newClient
 }
 
 // NewClient is an alternative Client constructor intended for testing or
 // advanced usage, where a custom API implementation can be injected.
+//
+// A non-empty root overrides options.Endpoints.APIRoot; all other URLs are
+// resolved from options exactly as New does.
 func NewClient(root, auth string, options Config, api API) Client {
 	if api == nil {
-		api = new(itembaseAPI)
+		api = newAPI(options)
 	}
+	api = chain(api, options.Middleware)
 
-	newClient := &client{url: root, root: root, auth: auth, api: api, options: options, production: options.Production}
-	newClient.newConf()
+	if root != "" {
+		options.Endpoints.APIRoot = root
+	}
+
+	newClient := &client{auth: auth, api: api, options: options, production: options.Production, app: new(appToken)}
+	newClient.setEndpoints()
+	newClient.url = newClient.root
 
 	return newClient
 }
 
+// clone returns a copy of the client that can be modified without affecting
+// the receiver.
+func (c *client) clone() *client {
+	d := *c
+	d.params = make(map[string]string, len(c.params))
+	for key, value := range c.params {
+		d.params[key] = value
+	}
+	return &d
+}
+
 func (c *client) URL() string {
 	return c.url
 }
 
 func (c *client) Sandbox() Client {
-	c.production = false
-	return c
+	d := c.clone()
+	d.production = false
+	d.setEndpoints()
+	d.app = new(appToken)
+	return d
 }
 
+// User returns a client for the given user. If no token can be obtained for
+// the user, every call made through the returned client fails with the
+// corresponding error; use ForUser to handle it right away.
 func (c *client) User(user string) Client {
-	c.auth = c.getUserToken(user).AccessToken
-	c.user = user
-	c.params = make(map[string]string)
-	c.url = c.root + "/users/" + user
-	c.max = 0
-	return c
+	d, err := c.forUser(context.Background(), user)
+	d.err = err
+	return d
+}
+
+func (c *client) UserE(user string) (Client, error) {
+	return c.ForUser(context.Background(), user)
+}
+
+func (c *client) ForUser(ctx context.Context, user string) (Client, error) {
+	d, err := c.forUser(ctx, user)
+	if err != nil {
+		return nil, err
+	}
+	return d, nil
+}
+
+func (c *client) forUser(ctx context.Context, user string) (*client, error) {
+	d := c.clone()
+	d.auth = ""
+	d.user = user
+	d.params = make(map[string]string)
+	d.url = d.root + "/users/" + user
+	d.max = 0
+	d.err = nil
+	d.granted = nil
+	d.scope = ""
+
+	token, err := d.getUserToken(ctx, user)
+	if err != nil {
+		return d, err
+	}
+	d.auth = token.AccessToken
+	d.granted = grantedScopes(token)
+
+	return d, nil
+}
+
+// call performs a single request for the client's endpoint, failing with
+// ErrMissingScope if its token lacks the scope the endpoint needs.
+func (c *client) call(ctx context.Context, method, path string, dest interface{}) error {
+	if c.err != nil {
+		return c.err
+	}
+	if err := c.checkScope(); err != nil {
+		return err
+	}
+
+	return c.do(ctx, method, path, dest)
+}
+
+// do performs a single request against the underlying API, using
+// CallContext when the API supports it.
+func (c *client) do(ctx context.Context, method, path string, dest interface{}) error {
+	if c.err != nil {
+		return c.err
+	}
+
+	return CallAPI(withUser(ctx, c.user), c.api, method, path, c.auth, nil, c.params, dest)
 }
 
 func (c *client) GetInto(destination interface{}) error {
-	err := c.api.Call("GET", c.url, c.auth, nil, c.params, &destination)
+	return c.GetIntoContext(context.Background(), destination)
+}
+
+func (c *client) GetIntoContext(ctx context.Context, destination interface{}) error {
+	err := c.call(ctx, "GET", c.url, &destination)
 	if err != nil {
 		return err
 	}
@@ -106,7 +200,11 @@ func (c *client) GetInto(destination interface{}) error {
 }
 
 func (c *client) Get() (destination interface{}, err error) {
-	err = c.api.Call("GET", c.url, c.auth, nil, c.params, &destination)
+	return c.GetContext(context.Background())
+}
+
+func (c *client) GetContext(ctx context.Context) (destination interface{}, err error) {
+	err = c.call(ctx, "GET", c.url, &destination)
 	return
 }
 
@@ -115,104 +213,40 @@ func (c *client) GetAllInto(destination interface {
 	Count() int
 	MaxCreatedAt() time.Time
 	MaxUpdatedAt() time.Time
-}) (err error) {
+}) error {
+	return c.GetAllIntoContext(context.Background(), destination)
+}
 
-	var response ItembaseResponse
-	DocumentsReceived := 0
+func (c *client) GetAllIntoContext(ctx context.Context, destination interface {
+	Add(interface{}) error
+	Count() int
+	MaxCreatedAt() time.Time
+	MaxUpdatedAt() time.Time
+}) error {
 
-	err = c.api.Call("GET", c.url, c.auth, nil, c.params, &response)
-	if err != nil {
-		return
-	}
+	it := c.IterateContext(ctx)
 
-	for _, document := range response.Documents {
-		if destination.Add != nil {
-			err = destination.Add(document)
-			if err != nil {
-				log.Info("Error when adding document", "error", err)
-			}
+	for it.Next() {
+		if err := destination.Add(it.Document()); err != nil {
+			c.options.logger().Warn("adding document failed", "user", c.user, "endpoint", c.url, "error", err)
 		}
 	}
 
-	log.Debug("Documents", "found", response.NumDocumentsFound)
-	log.Debug("Documents", "returned", response.NumDocumentsReturned)
+	c.options.logger().Debug("fetched all documents", "user", c.user, "endpoint", c.url, "found", it.Found(), "saved", destination.Count())
 
-	if response.NumDocumentsFound == response.NumDocumentsReturned {
-		log.Debug("same amount of documents that were found as returned")
-		return
-	} else {
-		DocumentsReceived = response.NumDocumentsReturned
-		TotalDocuments := response.NumDocumentsFound
-		log.Debug("expecting to receive", "NumDocumentsFound", response.NumDocumentsFound)
-
-		for DocumentsReceived < TotalDocuments {
-
-			log.Debug("expecting", "TotalDocuments", TotalDocuments)
-
-			if c.max > 0 {
-				log.Debug("Max", "max", c.max)
-				if DocumentsReceived >= c.max {
-					log.Debug("Max is reached", "DocumentsReceived", DocumentsReceived)
-					return
-				}
-			}
-
-			if _, ok := c.params["created_at_from"]; ok {
-				if c.params["created_at_from"] == destination.MaxCreatedAt().Format(time.RFC3339) {
-					log.Error("created_at_from equals to previous created_at_from - We got a loop?", "created_at_from", c.params["created_at_from"])
-					return
-				}
-			}
-
-			c = c.clientWithNewParam("start_at_document", DocumentsReceived)
-			err = c.api.Call("GET", c.url, c.auth, nil, c.params, &response)
-
-			if err != nil {
-				log.Error("Error when retrieving paginated results", "error", err)
-			}
-
-			log.Debug("Documents", "found", response.NumDocumentsFound)
-			log.Debug("Documents", "returned", response.NumDocumentsReturned)
-
-			if len(response.Documents) == 0 {
-				log.Debug("no documents in response", "response.Documents", len(response.Documents))
-				return
-			}
-
-			for _, document := range response.Documents {
-				if destination.Add != nil {
-					destination.Add(document)
-					if err != nil {
-						log.Info("Error when adding document", "error", err)
-					}
-				}
-			}
-
-			if DocumentsReceived == destination.Count() {
-				log.Error("Same amount of documents in destination interface as before. Can happen due to created_at collission during pagination.")
-				return
-			}
-
-			log.Debug("Documents", "saved", destination.Count())
-			DocumentsReceived = destination.Count()
-
-			if len(response.Documents) == 1 {
-				log.Debug("only 1 document in response", "response.Documents", len(response.Documents))
-				return
-			}
-
-		}
-	}
-
-	return
+	return it.Err()
 }
 
 func (c *client) Found() (count int, err error) {
+	return c.FoundContext(context.Background())
+}
+
+func (c *client) FoundContext(ctx context.Context) (count int, err error) {
 
 	var response ItembaseResponse
 
 	d := c.clientWithNewParam("limit", 1)
-	err = d.api.Call("GET", d.url, d.auth, nil, d.params, &response)
+	err = d.call(ctx, "GET", d.url, &response)
 
 	if err != nil {
 		return
@@ -223,38 +257,59 @@ func (c *client) Found() (count int, err error) {
 }
 
 func (c *client) Me() (destination User, err error) {
-	err = c.api.Call("GET", c.me, c.auth, nil, c.params, &destination)
+	return c.MeContext(context.Background())
+}
+
+func (c *client) MeContext(ctx context.Context) (destination User, err error) {
+	err = c.do(ctx, "GET", c.me, &destination)
 	return
 }
 
 func (c *client) Activate() (destination interface{}, err error) {
-	err = c.api.Call("GET", c.activation+"/activate", c.auth, nil, c.params, &destination)
+	return c.ActivateContext(context.Background())
+}
+
+func (c *client) ActivateContext(ctx context.Context) (destination interface{}, err error) {
+	if c.auth == "" && c.err == nil && c.options.ClientCredentials {
+		app, err := c.forApp(ctx)
+		if err != nil {
+			return nil, err
+		}
+		return app.ActivateContext(ctx)
+	}
+
+	err = c.do(ctx, "GET", c.activation+"/activate", &destination)
 	return
 }
 
 func (c *client) Child(path string) Client {
-	c.url = c.url + "/" + path
-	return c
+	d := c.clone()
+	d.url = c.url + "/" + path
+	return d
+}
+
+// entity returns a client for one of the user's entity collections.
+func (c *client) entity(name string) *client {
+	d := c.clone()
+	d.url = c.root + "/users/" + c.user + "/" + name
+	d.scope = entityScopes[name]
+	return d
 }
 
 func (c *client) Transactions() Client {
-	c.url = c.root + "/users/" + c.user + "/transactions"
-	return c
+	return c.entity("transactions")
 }
 
 func (c *client) Products() Client {
-	c.url = c.root + "/users/" + c.user + "/products"
-	return c
+	return c.entity("products")
 }
 
 func (c *client) Buyers() Client {
-	c.url = c.root + "/users/" + c.user + "/buyers"
-	return c
+	return c.entity("buyers")
 }
 
 func (c *client) Profiles() Client {
-	c.url = c.root + "/users/" + c.user + "/profiles"
-	return c
+	return c.entity("profiles")
 }
 
 // These are some shenanigans, golang. Shenanigans I say.
@@ -274,14 +329,16 @@ func (c *client) newParamMap(key string, value interface{}) map[string]string {
 }
 
 func (c *client) clientWithNewParam(key string, value interface{}) *client {
-	c.params = c.newParamMap(key, value)
-	return c
+	d := c.clone()
+	d.params = c.newParamMap(key, value)
+	return d
 }
 
 // Query functions.
 func (c *client) Select(prop string) Client {
-	c.url = c.url + "/" + prop
-	return c
+	d := c.clone()
+	d.url = c.url + "/" + prop
+	return d
 }
 
 func (c *client) CreatedAtFrom(value time.Time) Client {
@@ -309,6 +366,7 @@ func (c *client) Offset(offset uint) Client {
 }
 
 func (c *client) Max(max int) Client {
-	c.max = max
-	return c
+	d := c.clone()
+	d.max = max
+	return d
 }
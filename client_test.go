@@ -0,0 +1,125 @@
+package itembase_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+
+	"golang.org/x/oauth2"
+	"gopkg.in/saasbuilders/itembase.v0"
+	"gopkg.in/saasbuilders/itembase.v0/itembasetest"
+)
+
+const testUserID = "13ac2c74-7de3-4436-9a6d-2c94dd2b1fd3"
+
+// newTestUser starts a fake itembase seeded with fixture for testUserID, and
+// returns a client for that user.
+func newTestUser(t *testing.T, fixture itembasetest.Fixture) (*itembasetest.Server, itembase.Client) {
+	t.Helper()
+
+	server := itembasetest.NewServer()
+	t.Cleanup(server.Close)
+
+	fixture.User.UUID = testUserID
+	if err := server.Seed(fixture); err != nil {
+		t.Fatal(err)
+	}
+
+	storeRef, err := itembase.New(server.Config(), nil).ForUser(context.Background(), testUserID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return server, storeRef
+}
+
+// Run with -race: derived clients must not share mutable state with their
+// root.
+func TestClientConcurrentQueries(t *testing.T) {
+	var fixture itembasetest.Fixture
+	for i := 0; i < 30; i++ {
+		fixture.Transactions = append(fixture.Transactions, itembase.Transaction{ID: itembase.TransactionID(fmt.Sprintf("t%02d", i))})
+		fixture.Products = append(fixture.Products, itembase.Product{ID: itembase.ProductID(fmt.Sprintf("p%02d", i))})
+	}
+	_, storeRef := newTestUser(t, fixture)
+	rootURL := storeRef.URL()
+
+	var wg sync.WaitGroup
+	for i := 1; i <= 20; i++ {
+		wg.Add(2)
+
+		go func(limit uint) {
+			defer wg.Done()
+
+			query := storeRef.Transactions().Limit(limit)
+			if url := query.URL(); strings.Count(url, "transactions") != 1 {
+				t.Errorf("Limit(%d): URL %q", limit, url)
+			}
+
+			var transactions itembase.Transactions
+			if err := query.GetInto(&transactions); err != nil {
+				t.Errorf("Limit(%d): %v", limit, err)
+				return
+			}
+			if transactions.Count() != int(limit) {
+				t.Errorf("Limit(%d): got %d transactions", limit, transactions.Count())
+			}
+		}(uint(i))
+
+		go func(id string) {
+			defer wg.Done()
+
+			query := storeRef.Products().Select(id)
+			if url := query.URL(); !strings.HasSuffix(url, "/products/"+id) {
+				t.Errorf("Select(%q): URL %q", id, url)
+			}
+
+			var product itembase.Product
+			if err := query.GetInto(&product); err != nil {
+				t.Errorf("Select(%q): %v", id, err)
+				return
+			}
+			if string(product.ID) != id {
+				t.Errorf("Select(%q): got product %q", id, product.ID)
+			}
+		}(fmt.Sprintf("p%02d", i))
+	}
+	wg.Wait()
+
+	if storeRef.URL() != rootURL {
+		t.Errorf("root URL changed from %q to %q", rootURL, storeRef.URL())
+	}
+}
+
+func TestNewUserTokenSaveFailure(t *testing.T) {
+	server := itembasetest.NewServer()
+	t.Cleanup(server.Close)
+	server.AddUser(itembase.User{UUID: testUserID})
+
+	errDatabase := errors.New("database down")
+	for _, saver := range []func(string, *oauth2.Token) error{
+		nil,
+		func(string, *oauth2.Token) error { return errDatabase },
+	} {
+		config := server.Config()
+		config.RedirectURL = "https://app.example/oauth/callback"
+		config.TokenHandler = itembase.ItembaseTokens{
+			TokenLoader:      func(string) (*oauth2.Token, error) { return nil, nil },
+			TokenSaver:       saver,
+			TokenPermissions: consent(server, func(requested string) string { return requested }),
+		}
+
+		_, err := itembase.New(config, nil).ForUser(context.Background(), testUserID)
+		if !errors.Is(err, itembase.ErrTokenSave) {
+			t.Errorf("err = %v, want ErrTokenSave", err)
+		}
+		if saver == nil && !errors.Is(err, itembase.ErrNoTokenStore) {
+			t.Errorf("err = %v, want ErrNoTokenStore", err)
+		}
+		if saver != nil && !errors.Is(err, errDatabase) {
+			t.Errorf("err = %v, want the TokenSaver's error", err)
+		}
+	}
+}
@@ -0,0 +1,262 @@
+package itembase
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// An Entity is a document from one of the itembase collection endpoints that
+// can be stored in a Collection.
+type Entity interface {
+	// EntityID returns the itembase ID of the document.
+	EntityID() string
+	// Created returns the creation date of the document, if known.
+	Created() *time.Time
+	// Updated returns the date of the last update of the document, if known.
+	Updated() *time.Time
+	// Source returns the ID of the shop the document originates from.
+	Source() string
+}
+
+// A Collection is a container for pagination of itembase entities. Documents
+// are kept in the order they were first added and deduplicated by ID: adding
+// a document whose ID is already present replaces the stored one only if it
+// has a more recent UpdatedAt.
+//
+// The zero value is an empty collection ready to use. A Collection can be
+// passed to GetInto, which fills Documents directly, or to GetAllInto.
+// Documents may be replaced or appended to, but documents already in the
+// collection should not be changed in place. Collections may be copied; a
+// copy rebuilds its own index the first time it is used.
+type Collection[T Entity] struct {
+	Documents []T `json:"documents"`
+
+	index index[T]
+}
+
+// Add decodes document and upserts it into the collection. The document may be
+// a T, a *T, raw JSON, or any value that marshals to the JSON of a T.
+func (collection *Collection[T]) Add(document interface{}) error {
+	return collection.index.add(&collection.Documents, document)
+}
+
+// Upsert adds entity to the collection, or replaces the stored document with
+// the same ID if entity was updated more recently. It reports whether the
+// collection changed.
+func (collection *Collection[T]) Upsert(entity T) bool {
+	return collection.index.upsert(&collection.Documents, entity)
+}
+
+// Get returns the document with the given ID.
+func (collection *Collection[T]) Get(id string) (entity T, ok bool) {
+	return collection.index.get(&collection.Documents, id)
+}
+
+// Exists reports whether a document with the same ID as entity is present.
+func (collection *Collection[T]) Exists(entity T) bool {
+	_, ok := collection.Get(entity.EntityID())
+	return ok
+}
+
+func (collection *Collection[T]) Count() int {
+	collection.index.reindex(&collection.Documents)
+	return len(collection.Documents)
+}
+
+// Return date of heighest Created At document
+func (collection *Collection[T]) MaxCreatedAt() time.Time {
+	collection.index.reindex(&collection.Documents)
+	return collection.index.maxCreatedAt
+}
+
+// Return date of heighest Updated At document
+func (collection *Collection[T]) MaxUpdatedAt() time.Time {
+	collection.index.reindex(&collection.Documents)
+	return collection.index.maxUpdatedAt
+}
+
+// Filter returns a new collection holding only the documents for which keep
+// returns true, e.g. transactions.Filter((*Transaction).Completed).
+func (collection *Collection[T]) Filter(keep func(*T) bool) Collection[T] {
+	return collection.index.filter(&collection.Documents, keep)
+}
+
+// ByShop returns the documents originating from the shop with the given ID.
+func (collection *Collection[T]) ByShop(shopID string) Collection[T] {
+	return collection.Filter(fromShop[T](shopID))
+}
+
+// UnmarshalJSON replaces the documents of the collection with the ones of a
+// collection response, deduplicated by ID.
+func (collection *Collection[T]) UnmarshalJSON(data []byte) error {
+	documents, err := unmarshalDocuments[T](data)
+	if err != nil {
+		return err
+	}
+	collection.Documents = documents
+	collection.index.reset(&collection.Documents)
+	return nil
+}
+
+// index is the ID index of a Collection. It is kept apart from the documents
+// so the named collection types, which keep their documents in fields named
+// after the entity, share the implementation.
+type index[T Entity] struct {
+	ids          map[string]int
+	maxCreatedAt time.Time
+	maxUpdatedAt time.Time
+
+	// owner, first and length describe the documents ids was built for, to
+	// notice when the collection was copied, or its documents were replaced
+	// or appended to behind the index's back.
+	owner  *[]T
+	first  *T
+	length int
+}
+
+func (x *index[T]) add(documents *[]T, document interface{}) error {
+	var entity T
+
+	switch d := document.(type) {
+	case T:
+		entity = d
+	case *T:
+		entity = *d
+	case json.RawMessage:
+		if err := json.Unmarshal(d, &entity); err != nil {
+			return err
+		}
+	case []byte:
+		if err := json.Unmarshal(d, &entity); err != nil {
+			return err
+		}
+	default:
+		if err := ConvertTo(document, &entity); err != nil {
+			return err
+		}
+	}
+
+	x.upsert(documents, entity)
+	return nil
+}
+
+func (x *index[T]) upsert(documents *[]T, entity T) bool {
+	x.reindex(documents)
+
+	if !x.put(documents, entity) {
+		return false
+	}
+	x.track(documents)
+	return true
+}
+
+func (x *index[T]) get(documents *[]T, id string) (entity T, ok bool) {
+	x.reindex(documents)
+
+	i, ok := x.ids[id]
+	if ok && (i >= len(*documents) || (*documents)[i].EntityID() != id) {
+		// a document was changed in place
+		x.reset(documents)
+		i, ok = x.ids[id]
+	}
+	if !ok {
+		return entity, false
+	}
+	return (*documents)[i], true
+}
+
+func (x *index[T]) filter(documents *[]T, keep func(*T) bool) (filtered Collection[T]) {
+	x.reindex(documents)
+
+	for i := range *documents {
+		if keep(&(*documents)[i]) {
+			filtered.Upsert((*documents)[i])
+		}
+	}
+	return
+}
+
+// reindex rebuilds the index if documents changed since it was built.
+func (x *index[T]) reindex(documents *[]T) {
+	if x.ids != nil && x.owner == documents && x.length == len(*documents) && x.first == firstOf(*documents) {
+		return
+	}
+	x.reset(documents)
+}
+
+// reset rebuilds the index from scratch, dropping duplicate IDs from
+// documents on the way.
+func (x *index[T]) reset(documents *[]T) {
+	all := *documents
+	*documents = make([]T, 0, len(all))
+	x.ids = make(map[string]int, len(all))
+	x.maxCreatedAt = time.Time{}
+	x.maxUpdatedAt = time.Time{}
+
+	for _, entity := range all {
+		x.put(documents, entity)
+	}
+	x.track(documents)
+}
+
+// put upserts entity without checking the index first, and reports whether
+// documents changed.
+func (x *index[T]) put(documents *[]T, entity T) bool {
+	if i, ok := x.ids[entity.EntityID()]; ok {
+		if !updatedAfter(entity.Updated(), (*documents)[i].Updated()) {
+			return false
+		}
+		(*documents)[i] = entity
+	} else {
+		x.ids[entity.EntityID()] = len(*documents)
+		*documents = append(*documents, entity)
+	}
+
+	if createdAt := entity.Created(); createdAt != nil && createdAt.After(x.maxCreatedAt) {
+		x.maxCreatedAt = *createdAt
+	}
+	if updatedAt := entity.Updated(); updatedAt != nil && updatedAt.After(x.maxUpdatedAt) {
+		x.maxUpdatedAt = *updatedAt
+	}
+	return true
+}
+
+// track remembers documents as the ones the index was built for.
+func (x *index[T]) track(documents *[]T) {
+	x.owner = documents
+	x.first = firstOf(*documents)
+	x.length = len(*documents)
+}
+
+func firstOf[T any](documents []T) *T {
+	if len(documents) == 0 {
+		return nil
+	}
+	return &documents[0]
+}
+
+// unmarshalDocuments decodes the documents of a collection response.
+func unmarshalDocuments[T Entity](data []byte) ([]T, error) {
+	var response struct {
+		Documents []T `json:"documents"`
+	}
+	if err := json.Unmarshal(data, &response); err != nil {
+		return nil, err
+	}
+	return response.Documents, nil
+}
+
+func fromShop[T Entity](shopID string) func(*T) bool {
+	return func(entity *T) bool {
+		return (*entity).Source() == shopID
+	}
+}
+
+// updatedAfter reports whether a is a more recent update date than b. A known
+// date is more recent than an unknown one.
+func updatedAfter(a, b *time.Time) bool {
+	if a == nil {
+		return false
+	}
+	return b == nil || a.After(*b)
+}
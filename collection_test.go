@@ -0,0 +1,106 @@
+package itembase_test
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"gopkg.in/saasbuilders/itembase.v0"
+)
+
+func TestCollectionUnmarshalReplacesIndex(t *testing.T) {
+	var transactions itembase.Collection[itembase.Transaction]
+
+	if err := json.Unmarshal([]byte(`{"documents":[{"id":"a"},{"id":"b"}]}`), &transactions); err != nil {
+		t.Fatal(err)
+	}
+	transactions.Count()
+	if err := json.Unmarshal([]byte(`{"documents":[{"id":"x"},{"id":"y"}]}`), &transactions); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, ok := transactions.Get("x"); !ok {
+		t.Error(`Get("x") = false, want true`)
+	}
+	if _, ok := transactions.Get("a"); ok {
+		t.Error(`Get("a") = true, want false`)
+	}
+
+	updated := time.Now()
+	transactions.Upsert(itembase.Transaction{ID: "a", UpdatedAt: &updated})
+	if got := ids(transactions.Documents); got != "x y a" {
+		t.Errorf("documents = %q, want %q", got, "x y a")
+	}
+}
+
+func TestNamedCollectionsKeepFields(t *testing.T) {
+	var transactions itembase.Transactions
+	transactions.Add(itembase.Transaction{ID: "a", Status: itembase.Status{Global: "completed"}})
+	transactions.Add(itembase.Transaction{ID: "b"})
+	transactions.Add(itembase.Transaction{ID: "a"})
+
+	if got := ids(transactions.Transactions); got != "a b" {
+		t.Errorf("Transactions = %q, want %q", got, "a b")
+	}
+	if completed := transactions.Completed(); ids(completed.Transactions) != "a" {
+		t.Errorf("Completed() = %q, want %q", ids(completed.Transactions), "a")
+	}
+
+	// documents replaced behind the collection's back, with the same length
+	transactions.Transactions = []itembase.Transaction{{ID: "x"}, {ID: "y"}}
+	if _, ok := transactions.Get("a"); ok {
+		t.Error(`Get("a") = true after replacing Transactions`)
+	}
+
+	var products itembase.Products
+	products.Add(itembase.Product{ID: "p", StockInformation: itembase.StockInformation{InStock: true}})
+	products.Add(itembase.Product{ID: "q"})
+	if inStock := products.InStock(); ids(inStock.Products) != "p" {
+		t.Errorf("InStock() = %q, want %q", ids(inStock.Products), "p")
+	}
+}
+
+func TestCollectionCopy(t *testing.T) {
+	var a itembase.Transactions
+	a.Add(itembase.Transaction{ID: "1"})
+	b := a
+	b.Add(itembase.Transaction{ID: "2"})
+
+	if _, ok := a.Get("2"); ok {
+		t.Error(`a.Get("2") = true after adding to the copy`)
+	}
+	if a.Exists(itembase.Transaction{ID: "2"}) {
+		t.Error(`a.Exists("2") = true after adding to the copy`)
+	}
+	a.Add(itembase.Transaction{ID: "3"})
+	if got := ids(a.Transactions); got != "1 3" {
+		t.Errorf("a = %q, want %q", got, "1 3")
+	}
+	if got := ids(b.Transactions); got != "1 2" {
+		t.Errorf("b = %q, want %q", got, "1 2")
+	}
+
+	completed := a.Completed()
+	completed.Add(itembase.Transaction{ID: "4"})
+	if _, ok := completed.Get("4"); !ok {
+		t.Error(`completed.Get("4") = false, want true`)
+	}
+
+	var c itembase.Collection[itembase.Product]
+	c.Add(itembase.Product{ID: "p"})
+	d := c
+	d.Add(itembase.Product{ID: "q"})
+	if _, ok := c.Get("q"); ok || c.Count() != 1 {
+		t.Errorf(`c.Get("q") = %v, c.Count() = %d after adding to the copy`, ok, c.Count())
+	}
+}
+
+func ids[T itembase.Entity](documents []T) (s string) {
+	for i, document := range documents {
+		if i > 0 {
+			s += " "
+		}
+		s += document.EntityID()
+	}
+	return
+}
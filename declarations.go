@@ -1,6 +1,9 @@
 package itembase
 
 import (
+	"context"
+	"net/http"
+	"strings"
 	"time"
 
 	"golang.org/x/oauth2"
@@ -29,11 +32,128 @@ type Config struct {
 	// RedirectURL is the URL to redirect users after requesting OAuth2
 	// permission grants from itembase. See oauth2.Config.
 	RedirectURL string
+
+	// ClientCredentials makes Activate, when called on a client that is not
+	// bound to a user, authenticate as the application through the client
+	// credentials grant. See Client.App.
+	ClientCredentials bool
+
+	// AppScopes are the scopes requested for the application token of the
+	// client credentials grant.
+	AppScopes []string
+
+	// PKCE adds an S256 code challenge (RFC 7636) to authorization requests
+	// and the matching verifier to the code exchange. It lets public
+	// clients, such as desktop tools, authorize without a ClientSecret.
+	PKCE bool
+
+	// Logger receives the client's log records. Nothing is logged when it is
+	// nil. Use SlogLogger to log through log/slog.
+	Logger Logger
+
+	// HTTPClient, if set, is used for all requests to itembase: API calls as
+	// well as OAuth2 token exchanges and refreshes. Use it to configure
+	// proxies, TLS roots or test transports.
+	HTTPClient *http.Client
+
+	// RetryPolicy controls how failed calls are retried. DefaultRetryPolicy
+	// is used when it is nil; set MaxAttempts to 1 to disable retries.
+	RetryPolicy *RetryPolicy
+
+	// RateLimiter, if set, throttles all requests of clients created with
+	// this Config. Share one RateLimiter between all Configs of the same
+	// application.
+	RateLimiter *RateLimiter
+
+	// CircuitBreaker, if set, makes calls fail fast with ErrCircuitOpen
+	// while an itembase host is degraded. Like RateLimiter, it should be
+	// shared between all Configs of an application.
+	CircuitBreaker *CircuitBreaker
+
+	// Middleware wraps the API used by clients created with this Config,
+	// including one passed to New or NewClient. The first Middleware is the
+	// outermost.
+	Middleware []Middleware
+
+	// Endpoints overrides the itembase URLs selected by Production, e.g. to
+	// point a Client at a local stand-in, an egress proxy or an
+	// itembasetest.Server. Empty fields default to the URLs of
+	// ProductionEndpoints or SandboxEndpoints.
+	Endpoints Endpoints
+}
+
+// Endpoints holds the URLs of the itembase services used by a Client.
+type Endpoints struct {
+	// AuthURL is the OAuth2 authorization endpoint.
+	AuthURL string
+
+	// TokenURL is the OAuth2 token endpoint.
+	TokenURL string
+
+	// MeURL is the URL of the "me" endpoint, returning the current user.
+	MeURL string
+
+	// APIRoot is the base URL of the REST API, under which the /users
+	// resources live.
+	APIRoot string
+
+	// ActivationURL is the base URL of the solution service used by Activate.
+	ActivationURL string
+
+	// RevocationURL is the OAuth2 token revocation endpoint (RFC 7009) used
+	// by Disconnect. Tokens are not revoked if it is empty, which is the
+	// default for both environments.
+	RevocationURL string
+}
+
+var (
+	// ProductionEndpoints are the URLs of the itembase production
+	// environment, used when Config.Production is set.
+	ProductionEndpoints = Endpoints{
+		AuthURL:       "https://accounts.itembase.com/oauth/v2/auth",
+		TokenURL:      "https://accounts.itembase.com/oauth/v2/token",
+		MeURL:         "https://users.itembase.com/v1/me",
+		APIRoot:       "https://api.itembase.io/v1",
+		ActivationURL: "https://solutionservice.itembase.com",
+	}
+
+	// SandboxEndpoints are the URLs of the itembase sandbox environment.
+	SandboxEndpoints = Endpoints{
+		AuthURL:       "http://sandbox.accounts.itembase.io/oauth/v2/auth",
+		TokenURL:      "http://sandbox.accounts.itembase.io/oauth/v2/token",
+		MeURL:         "http://sandbox.users.itembase.io/v1/me",
+		APIRoot:       "http://sandbox.api.itembase.io/v1",
+		ActivationURL: "http://sandbox.solutionservice.itembase.io",
+	}
+)
+
+// withDefaults returns e with its empty fields taken from defaults and
+// trailing slashes removed.
+func (e Endpoints) withDefaults(defaults Endpoints) Endpoints {
+	pick := func(value, fallback string) string {
+		if value == "" {
+			value = fallback
+		}
+		return strings.TrimRight(value, "/")
+	}
+
+	return Endpoints{
+		AuthURL:       pick(e.AuthURL, defaults.AuthURL),
+		TokenURL:      pick(e.TokenURL, defaults.TokenURL),
+		MeURL:         pick(e.MeURL, defaults.MeURL),
+		APIRoot:       pick(e.APIRoot, defaults.APIRoot),
+		ActivationURL: pick(e.ActivationURL, defaults.ActivationURL),
+		RevocationURL: pick(e.RevocationURL, defaults.RevocationURL),
+	}
 }
 
 // A Client retrieves data from the itembase API. Use itembase.New to create an
 // instance of the default implementation.
 //
+// Clients are immutable: methods such as User, Transactions, Select or Limit
+// return a new derived Client and leave the receiver untouched, so one Client
+// may be shared by several goroutines and reused for any number of queries.
+//
 // TODO: document each method
 type Client interface {
 	// Returns the absolute URL path for the client
@@ -43,8 +163,18 @@ type Client interface {
 	// the passed in destination.
 	GetInto(destination interface{}) error
 
+	// GetIntoContext is like GetInto, but aborts the request when ctx is
+	// cancelled or its deadline expires.
+	GetIntoContext(ctx context.Context, destination interface{}) error
+
+	// Iterate returns an Iterator that streams all documents referenced by
+	// the client, one page at a time.
+	Iterate() *Iterator
+	IterateContext(ctx context.Context) *Iterator
+
 	// Paginates through all possible values from client, and unmarshals
-	// into the passed in destination
+	// into the passed in destination. Documents are handed to Add as
+	// json.RawMessage values.
 	GetAllInto(destination interface {
 		Add(interface{}) error
 		Count() int
@@ -52,14 +182,27 @@ type Client interface {
 		MaxUpdatedAt() time.Time
 	}) error
 
+	// GetAllIntoContext is like GetAllInto, but stops paginating and returns
+	// ctx.Err() once ctx is done.
+	GetAllIntoContext(ctx context.Context, destination interface {
+		Add(interface{}) error
+		Count() int
+		MaxCreatedAt() time.Time
+		MaxUpdatedAt() time.Time
+	}) error
+
 	// Returns how many documents were found
 	Found() (count int, err error)
+	FoundContext(ctx context.Context) (count int, err error)
 
 	// Gets values referenced by the client, and returns them as generic interface(!)
 	Get() (destination interface{}, err error)
+	GetContext(ctx context.Context) (destination interface{}, err error)
 
 	Me() (destination User, err error)
+	MeContext(ctx context.Context) (destination User, err error)
 	Activate() (destination interface{}, err error)
+	ActivateContext(ctx context.Context) (destination interface{}, err error)
 
 	// Child returns a reference to the child specified by `path`. This does not
 	// actually make a request to itembase, but you can then manipulate the reference
@@ -73,8 +216,34 @@ type Client interface {
 
 	Sandbox() Client
 
+	// User returns a client for the given user ID. If the user's token
+	// cannot be obtained, all calls made through the returned client fail
+	// with that error.
 	User(path string) Client
 
+	// UserE is like User, but returns the token error right away.
+	UserE(user string) (Client, error)
+
+	// ForUser is like UserE, but obtains the user's token with ctx.
+	ForUser(ctx context.Context, user string) (Client, error)
+
+	// App returns a client authenticated as the application through the
+	// client credentials grant. The application token is cached through the
+	// TokenLoader and TokenSaver under AppTokenKey.
+	App() Client
+
+	// ForApp is like App, but obtains the token with ctx and returns its
+	// error right away.
+	ForApp(ctx context.Context) (Client, error)
+
+	// GrantedScopes returns the scopes granted with the user's token, or
+	// nil if the token response did not include them.
+	GrantedScopes() []string
+
+	// UpgradeScopes obtains a new token for the user, asking through
+	// TokenPermissions for those of scopes that were not granted yet.
+	UpgradeScopes(ctx context.Context, scopes ...string) (Client, error)
+
 	Select(prop string) Client
 	CreatedAtFrom(value time.Time) Client
 	CreatedAtTo(value time.Time) Client
@@ -89,8 +258,17 @@ type Client interface {
 	GetCachedToken(userID string) (token *oauth2.Token, err error)
 	GiveTokenPermissions(authURL string) (authcode string, err error)
 
-	HandleOAuthCode(authcode string) (*oauth2.Token, error)
+	// AuthCodeURL returns the URL of the itembase consent page for the
+	// application, see OAuthHandler for a complete authorization flow.
+	AuthCodeURL(state string, opts ...oauth2.AuthCodeOption) string
+	HandleOAuthCode(authcode string, opts ...oauth2.AuthCodeOption) (*oauth2.Token, error)
+	HandleOAuthCodeContext(ctx context.Context, authcode string, opts ...oauth2.AuthCodeOption) (*oauth2.Token, error)
 	GetUserIDForToken(token *oauth2.Token) (string, error)
+	GetUserIDForTokenContext(ctx context.Context, token *oauth2.Token) (string, error)
+
+	// Disconnect revokes the token of a user at Endpoints.RevocationURL,
+	// deletes it through the TokenDeleter and calls OnDisconnect.
+	Disconnect(ctx context.Context, userID string) error
 }
 
 // API is the internal interface for interacting with Itembase. The internal
@@ -116,12 +294,36 @@ type API interface {
 	Call(method, path, auth string, body interface{}, params map[string]string, dest interface{}) error
 }
 
+// ContextAPI is an optional extension of API for implementations that can
+// honour cancellation and deadlines. When the API passed to New or NewClient
+// also implements ContextAPI, the client uses CallContext for every request;
+// otherwise it falls back to Call, checking the context only before the call.
+// The context passed by a Client carries the user the call is made for, see
+// UserFromContext.
+type ContextAPI interface {
+	API
+
+	// CallContext is like Call, but aborts the HTTP transaction when ctx is
+	// cancelled or its deadline expires.
+	CallContext(ctx context.Context, method, path, auth string, body interface{}, params map[string]string, dest interface{}) error
+}
+
 // ItembaseTokens is a container struct holding handler functions for events in
 // an OAuth2 token's lifecycle.
 type ItembaseTokens struct {
 	TokenLoader      TokenLoader
 	TokenSaver       TokenSaver
 	TokenPermissions TokenPermissions
+
+	// TokenLister is only needed by a TokenRefresher.
+	TokenLister TokenLister
+
+	// TokenDeleter is called by Disconnect to remove a user's token.
+	TokenDeleter TokenDeleter
+
+	// OnDisconnect, if set, is called once Disconnect has revoked and
+	// deleted the token of a user.
+	OnDisconnect func(userID string)
 }
 
 // A TokenSaver is called at points during OAuth2 authorization flow when an
@@ -132,7 +334,17 @@ type TokenSaver func(userID string, token *oauth2.Token) (err error)
 // application might wish to retrieve a persisted token from a data store.
 type TokenLoader func(userID string) (token *oauth2.Token, err error)
 
+// A TokenLister returns the IDs of all users with a persisted token, for
+// background work such as refreshing tokens before they expire. The
+// application token stored under AppTokenKey is not a user's and should not
+// be listed.
+type TokenLister func() (userIDs []string, err error)
+
+// A TokenDeleter removes the persisted token of a user, e.g. when a shop is
+// disconnected or asks for its data to be erased.
+type TokenDeleter func(userID string) (err error)
+
 // A TokenPermissions handler is called at points during OAuth2 authorization
 // flow when a grantor might have granted new permissions for an authorization,
-// such as new scopes.
+// such as new scopes. Without one, such flows fail with ErrPermissionRequired.
 type TokenPermissions func(authURL string) (authcode string, err error)
@@ -0,0 +1,99 @@
+package itembase
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+var (
+	// ErrNoTokenDeleter is returned by Disconnect when the Config has no
+	// TokenDeleter.
+	ErrNoTokenDeleter = errors.New("itembase: no TokenDeleter")
+
+	// ErrRevocation is returned by Disconnect when itembase did not accept
+	// the revocation of a token.
+	ErrRevocation = errors.New("itembase: token revocation failed")
+)
+
+// Disconnect disconnects the shop of userID from the application: its refresh
+// and access tokens are revoked at Endpoints.RevocationURL, if set, then the
+// stored token is removed through the TokenDeleter and OnDisconnect is called.
+// Without a TokenDeleter, nothing is done and ErrNoTokenDeleter is returned.
+// Without a RevocationURL, the tokens are only deleted, and a warning is
+// logged. If revocation fails, the token is kept so Disconnect can be retried.
+func (c *client) Disconnect(ctx context.Context, userID string) error {
+	deleter := c.options.TokenHandler.TokenDeleter
+	if deleter == nil {
+		return ErrNoTokenDeleter
+	}
+
+	token, err := c.GetCachedToken(userID)
+	if err != nil && !errors.Is(err, ErrNoTokenCache) {
+		return err
+	}
+
+	switch {
+	case token == nil:
+	case c.endpoints.RevocationURL == "":
+		c.options.logger().Warn("tokens not revoked, no revocation URL is set", "user", userID)
+	default:
+		if err := c.revoke(ctx, token.RefreshToken, "refresh_token"); err != nil {
+			return err
+		}
+		if err := c.revoke(ctx, token.AccessToken, "access_token"); err != nil {
+			return err
+		}
+	}
+
+	if err := deleter(userID); err != nil {
+		return err
+	}
+
+	c.options.logger().Info("disconnected user", "user", userID)
+
+	if onDisconnect := c.options.TokenHandler.OnDisconnect; onDisconnect != nil {
+		onDisconnect(userID)
+	}
+	return nil
+}
+
+// revoke revokes a single token as described in RFC 7009. Empty tokens are
+// skipped.
+func (c *client) revoke(ctx context.Context, token, hint string) error {
+	if token == "" {
+		return nil
+	}
+
+	form := url.Values{
+		"token":           {token},
+		"token_type_hint": {hint},
+	}
+	request, err := http.NewRequestWithContext(ctx, "POST", c.endpoints.RevocationURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	request.SetBasicAuth(url.QueryEscape(c.options.ClientID), url.QueryEscape(c.options.ClientSecret))
+
+	client := c.options.HTTPClient
+	if client == nil {
+		client = httpClient
+	}
+
+	response, err := client.Do(request)
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrRevocation, err)
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(response.Body, 1<<10))
+		return fmt.Errorf("%w: %s: %s", ErrRevocation, response.Status, strings.TrimSpace(string(body)))
+	}
+	return nil
+}
@@ -0,0 +1,57 @@
+package itembase_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"gopkg.in/saasbuilders/itembase.v0"
+	"gopkg.in/saasbuilders/itembase.v0/itembasetest"
+)
+
+func TestDisconnect(t *testing.T) {
+	server := itembasetest.NewServer()
+	defer server.Close()
+	server.AddUser(itembase.User{UUID: testUserID})
+
+	config := server.Config()
+	var disconnected string
+	config.TokenHandler.OnDisconnect = func(userID string) { disconnected = userID }
+
+	storeRef, err := itembase.New(config, nil).ForUser(context.Background(), testUserID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := itembase.New(config, nil).Disconnect(context.Background(), testUserID); err != nil {
+		t.Fatal(err)
+	}
+	if disconnected != testUserID {
+		t.Errorf("OnDisconnect called for %q", disconnected)
+	}
+	if userIDs, _ := config.TokenHandler.TokenLister(); len(userIDs) != 0 {
+		t.Errorf("tokens still stored for %v", userIDs)
+	}
+	if _, err := storeRef.Me(); !errors.Is(err, itembase.ErrUnauthorized) {
+		t.Errorf("Me() after Disconnect: err = %v, want ErrUnauthorized", err)
+	}
+}
+
+func TestDisconnectNoTokenDeleter(t *testing.T) {
+	server := itembasetest.NewServer()
+	defer server.Close()
+	server.AddUser(itembase.User{UUID: testUserID})
+
+	config := server.Config()
+	config.TokenHandler.TokenDeleter = nil
+
+	called := false
+	config.TokenHandler.OnDisconnect = func(string) { called = true }
+
+	if err := itembase.New(config, nil).Disconnect(context.Background(), testUserID); !errors.Is(err, itembase.ErrNoTokenDeleter) {
+		t.Errorf("err = %v, want ErrNoTokenDeleter", err)
+	}
+	if called {
+		t.Error("OnDisconnect called")
+	}
+}
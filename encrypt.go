@@ -0,0 +1,232 @@
+package itembase
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+
+	"golang.org/x/oauth2"
+)
+
+// ErrDecrypt is returned when a stored token cannot be decrypted, because it
+// was encrypted with an unknown key or has been tampered with.
+var ErrDecrypt = errors.New("itembase: token decryption failed")
+
+// encryptedPrefix marks encrypted token values, which have the form
+// ibenc:v1:<key ID>:<base64 of nonce and ciphertext>.
+const encryptedPrefix = "ibenc:v1:"
+
+// An EncryptingTokenStore wraps the TokenLoader and TokenSaver of another
+// store and encrypts access and refresh tokens with AES-GCM before they are
+// saved. Each key has an ID stored with the values it encrypted, so keys can
+// be rotated: new values are encrypted with the primary key, and older keys
+// are kept for decryption only. Tokens stored under an older key, or before
+// encryption was enabled, are re-encrypted under the primary key when they
+// are next saved, or right away by Rotate.
+//
+//	encrypted, err := itembase.NewEncryptingTokenStore(store.Handler(), "2024-06", map[string][]byte{
+//		"2024-06": newKey,
+//		"2023-01": oldKey,
+//	})
+//	config.TokenHandler = encrypted.Handler()
+type EncryptingTokenStore struct {
+	handler ItembaseTokens
+	primary string
+	keys    map[string]cipher.AEAD
+}
+
+// NewEncryptingTokenStore returns an EncryptingTokenStore wrapping handler.
+// Keys are AES keys of 16, 24 or 32 bytes by key ID, and primary is the ID of
+// the key used for encryption.
+func NewEncryptingTokenStore(handler ItembaseTokens, primary string, keys map[string][]byte) (*EncryptingTokenStore, error) {
+	if handler.TokenLoader == nil || handler.TokenSaver == nil {
+		return nil, errors.New("itembase: encrypting token store needs a TokenLoader and a TokenSaver")
+	}
+	if _, ok := keys[primary]; !ok {
+		return nil, fmt.Errorf("itembase: no key with primary key ID %q", primary)
+	}
+
+	s := &EncryptingTokenStore{
+		handler: handler,
+		primary: primary,
+		keys:    make(map[string]cipher.AEAD, len(keys)),
+	}
+	for id, key := range keys {
+		if id == "" || strings.Contains(id, ":") {
+			return nil, fmt.Errorf("itembase: invalid key ID %q", id)
+		}
+		block, err := aes.NewCipher(key)
+		if err != nil {
+			return nil, fmt.Errorf("itembase: key %q: %w", id, err)
+		}
+		if s.keys[id], err = cipher.NewGCM(block); err != nil {
+			return nil, fmt.Errorf("itembase: key %q: %w", id, err)
+		}
+	}
+	return s, nil
+}
+
+// Handler returns the token handlers for use as Config.TokenHandler. The
+// handlers of the wrapped store other than TokenLoader and TokenSaver are
+// kept.
+func (s *EncryptingTokenStore) Handler() ItembaseTokens {
+	return ItembaseTokens{
+		TokenLoader:      s.Load,
+		TokenSaver:       s.Save,
+		TokenPermissions: s.handler.TokenPermissions,
+		TokenLister:      s.handler.TokenLister,
+		TokenDeleter:     s.handler.TokenDeleter,
+		OnDisconnect:     s.handler.OnDisconnect,
+	}
+}
+
+// Save encrypts the access and refresh token of token and saves it for userID
+// in the wrapped store.
+func (s *EncryptingTokenStore) Save(userID string, token *oauth2.Token) (err error) {
+	encrypted := *token
+	if encrypted.AccessToken, err = s.encrypt(userID, token.AccessToken); err != nil {
+		return err
+	}
+	if encrypted.RefreshToken, err = s.encrypt(userID, token.RefreshToken); err != nil {
+		return err
+	}
+	return s.handler.TokenSaver(userID, &encrypted)
+}
+
+// Load loads the token of userID from the wrapped store and decrypts it.
+// Tokens that are not encrypted are returned as they are.
+func (s *EncryptingTokenStore) Load(userID string) (*oauth2.Token, error) {
+	stored, err := s.handler.TokenLoader(userID)
+	if err != nil || stored == nil {
+		return stored, err
+	}
+
+	token, _, err := s.decryptToken(userID, stored)
+	return token, err
+}
+
+// decryptToken returns stored with its access and refresh token decrypted,
+// and whether both were encrypted under the primary key.
+func (s *EncryptingTokenStore) decryptToken(userID string, stored *oauth2.Token) (token *oauth2.Token, current bool, err error) {
+	decrypted := *stored
+	var access, refresh bool
+	if decrypted.AccessToken, access, err = s.decrypt(userID, stored.AccessToken); err != nil {
+		return nil, false, err
+	}
+	if decrypted.RefreshToken, refresh, err = s.decrypt(userID, stored.RefreshToken); err != nil {
+		return nil, false, err
+	}
+	return &decrypted, access && refresh, nil
+}
+
+// Rotate re-encrypts the tokens of userIDs, or of all users listed by the
+// wrapped store's TokenLister if none are given, that are not encrypted under
+// the primary key yet, and returns how many it saved again. Tokens that change
+// while they are rotated, e.g. because another process refreshed them, are
+// left alone, but the wrapped store cannot update a token only if it is
+// unchanged: run Rotate while tokens are not refreshed elsewhere, such as with
+// the TokenRefresher stopped.
+func (s *EncryptingTokenStore) Rotate(userIDs ...string) (rotated int, err error) {
+	if len(userIDs) == 0 {
+		if s.handler.TokenLister == nil {
+			return 0, ErrNoTokenLister
+		}
+		if userIDs, err = s.handler.TokenLister(); err != nil {
+			return 0, err
+		}
+	}
+
+	var errs []error
+	for _, userID := range userIDs {
+		ok, err := s.rotate(userID)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", userID, err))
+		}
+		if ok {
+			rotated++
+		}
+	}
+	return rotated, errors.Join(errs...)
+}
+
+// rotate re-encrypts the token of userID if needed, and reports whether it was
+// saved again.
+func (s *EncryptingTokenStore) rotate(userID string) (bool, error) {
+	stored, err := s.handler.TokenLoader(userID)
+	if err != nil || stored == nil {
+		return false, err
+	}
+
+	token, current, err := s.decryptToken(userID, stored)
+	if err != nil || current {
+		return false, err
+	}
+
+	encrypted := *token
+	if encrypted.AccessToken, err = s.encrypt(userID, token.AccessToken); err != nil {
+		return false, err
+	}
+	if encrypted.RefreshToken, err = s.encrypt(userID, token.RefreshToken); err != nil {
+		return false, err
+	}
+
+	// check for a token saved in the meantime as late as possible
+	latest, err := s.handler.TokenLoader(userID)
+	if err != nil || latest == nil || !sameToken(latest, stored) {
+		return false, err
+	}
+	return true, s.handler.TokenSaver(userID, &encrypted)
+}
+
+// encrypt returns value encrypted with the primary key, bound to userID.
+// Empty values are kept empty.
+func (s *EncryptingTokenStore) encrypt(userID, value string) (string, error) {
+	if value == "" {
+		return "", nil
+	}
+
+	aead := s.keys[s.primary]
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+
+	sealed := aead.Seal(nonce, nonce, []byte(value), []byte(userID))
+	return encryptedPrefix + s.primary + ":" + base64.RawURLEncoding.EncodeToString(sealed), nil
+}
+
+// decrypt returns the plaintext of value, and whether it was already
+// encrypted with the primary key. Values that are not encrypted are returned
+// as they are.
+func (s *EncryptingTokenStore) decrypt(userID, value string) (plaintext string, current bool, err error) {
+	if value == "" {
+		return "", true, nil
+	}
+	if !strings.HasPrefix(value, encryptedPrefix) {
+		return value, false, nil
+	}
+
+	id, encoded, found := strings.Cut(strings.TrimPrefix(value, encryptedPrefix), ":")
+	if !found {
+		return "", false, fmt.Errorf("%w: malformed value", ErrDecrypt)
+	}
+	aead, ok := s.keys[id]
+	if !ok {
+		return "", false, fmt.Errorf("%w: unknown key ID %q", ErrDecrypt, id)
+	}
+
+	sealed, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil || len(sealed) < aead.NonceSize() {
+		return "", false, fmt.Errorf("%w: malformed value", ErrDecrypt)
+	}
+
+	opened, err := aead.Open(nil, sealed[:aead.NonceSize()], sealed[aead.NonceSize():], []byte(userID))
+	if err != nil {
+		return "", false, fmt.Errorf("%w: %w", ErrDecrypt, err)
+	}
+	return string(opened), id == s.primary, nil
+}
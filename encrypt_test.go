@@ -0,0 +1,145 @@
+package itembase_test
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+
+	"golang.org/x/oauth2"
+	"gopkg.in/saasbuilders/itembase.v0"
+)
+
+// memoryTokens is a token store keeping tokens in a map, and counting the
+// tokens saved.
+type memoryTokens struct {
+	mu     sync.Mutex
+	tokens map[string]*oauth2.Token
+	saves  int
+}
+
+func newMemoryTokens() *memoryTokens {
+	return &memoryTokens{tokens: make(map[string]*oauth2.Token)}
+}
+
+func (m *memoryTokens) Handler() itembase.ItembaseTokens {
+	return itembase.ItembaseTokens{
+		TokenLoader: func(userID string) (*oauth2.Token, error) {
+			m.mu.Lock()
+			defer m.mu.Unlock()
+			return m.tokens[userID], nil
+		},
+		TokenSaver: func(userID string, token *oauth2.Token) error {
+			m.mu.Lock()
+			defer m.mu.Unlock()
+			m.tokens[userID] = token
+			m.saves++
+			return nil
+		},
+		TokenLister: func() (userIDs []string, err error) {
+			m.mu.Lock()
+			defer m.mu.Unlock()
+			for userID := range m.tokens {
+				userIDs = append(userIDs, userID)
+			}
+			return userIDs, nil
+		},
+		TokenDeleter: func(userID string) error {
+			m.mu.Lock()
+			defer m.mu.Unlock()
+			delete(m.tokens, userID)
+			return nil
+		},
+	}
+}
+
+func (m *memoryTokens) get(userID string) *oauth2.Token {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.tokens[userID]
+}
+
+func (m *memoryTokens) saved() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.saves
+}
+
+var (
+	oldKey = bytes.Repeat([]byte{1}, 32)
+	newKey = bytes.Repeat([]byte{2}, 16)
+)
+
+func TestEncryptingTokenStore(t *testing.T) {
+	store := newMemoryTokens()
+	encrypted, err := itembase.NewEncryptingTokenStore(store.Handler(), "new", map[string][]byte{"new": newKey})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := encrypted.Save("u1", &oauth2.Token{AccessToken: "access", RefreshToken: "refresh"}); err != nil {
+		t.Fatal(err)
+	}
+	if stored := store.get("u1"); strings.Contains(stored.AccessToken+stored.RefreshToken, "access") ||
+		strings.Contains(stored.AccessToken+stored.RefreshToken, "refresh") {
+		t.Errorf("stored in plaintext: %+v", stored)
+	}
+
+	token, err := encrypted.Load("u1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token.AccessToken != "access" || token.RefreshToken != "refresh" {
+		t.Errorf("Load(u1) = %+v", token)
+	}
+
+	// values are bound to their user
+	store.Handler().TokenSaver("u2", store.get("u1"))
+	if _, err := encrypted.Load("u2"); !errors.Is(err, itembase.ErrDecrypt) {
+		t.Errorf("Load(u2) err = %v, want ErrDecrypt", err)
+	}
+}
+
+func TestEncryptingTokenStoreRotate(t *testing.T) {
+	store := newMemoryTokens()
+	old, err := itembase.NewEncryptingTokenStore(store.Handler(), "old", map[string][]byte{"old": oldKey})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := old.Save("u1", &oauth2.Token{AccessToken: "a1", RefreshToken: "r1"}); err != nil {
+		t.Fatal(err)
+	}
+	store.Handler().TokenSaver("plain", &oauth2.Token{AccessToken: "a2", RefreshToken: "r2"})
+
+	encrypted, err := itembase.NewEncryptingTokenStore(store.Handler(), "new", map[string][]byte{"new": newKey, "old": oldKey})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// loading does not write
+	before := store.get("u1")
+	if token, err := encrypted.Load("u1"); err != nil || token.RefreshToken != "r1" {
+		t.Fatalf("Load(u1) = %+v, %v", token, err)
+	}
+	if store.get("u1") != before {
+		t.Error("Load saved the token again")
+	}
+
+	rotated, err := encrypted.Rotate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rotated != 2 {
+		t.Errorf("rotated %d tokens, want 2", rotated)
+	}
+	for _, userID := range []string{"u1", "plain"} {
+		if stored := store.get(userID); !strings.HasPrefix(stored.RefreshToken, "ibenc:v1:new:") {
+			t.Errorf("%s: refresh token %q not under the new key", userID, stored.RefreshToken)
+		}
+	}
+
+	if rotated, err := encrypted.Rotate(); rotated != 0 || err != nil {
+		t.Errorf("second Rotate() = %d, %v, want 0, nil", rotated, err)
+	}
+}
@@ -0,0 +1,131 @@
+package itembase
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"time"
+)
+
+var (
+	// ErrUnauthorized matches API errors caused by a missing, expired or
+	// insufficient access token (HTTP 401 and 403). The user has to be
+	// re-authorized.
+	ErrUnauthorized = errors.New("itembase: unauthorized")
+
+	// ErrRateLimited matches API errors caused by exceeding itembase's rate
+	// limits (HTTP 429).
+	ErrRateLimited = errors.New("itembase: rate limited")
+
+	// ErrNotFound matches API errors for resources that do not exist
+	// (HTTP 404).
+	ErrNotFound = errors.New("itembase: not found")
+
+	// ErrDecode is wrapped by errors returned when a response body could not
+	// be decoded.
+	ErrDecode = errors.New("itembase: decoding response failed")
+)
+
+// APIError is a Go representation of an error response sent back by itembase.
+// Use errors.Is with ErrUnauthorized, ErrRateLimited or ErrNotFound to
+// classify it, or errors.As to inspect it.
+type APIError struct {
+	// Message and Code are decoded from the itembase error body, and default
+	// to the HTTP status text and code.
+	Message string `json:"message"`
+	Code    int    `json:"code"`
+
+	// StatusCode is the HTTP status code of the response.
+	StatusCode int `json:"-"`
+
+	// Method and Endpoint identify the request, without query parameters.
+	Method   string `json:"-"`
+	Endpoint string `json:"-"`
+
+	// RequestID is the value of the response's X-Request-Id header, if any.
+	RequestID string `json:"-"`
+
+	// Body is the raw response body.
+	Body []byte `json:"-"`
+
+	// RetryAfter is the delay requested by the response's Retry-After
+	// header, if any.
+	RetryAfter time.Duration `json:"-"`
+}
+
+// Error is the former name of APIError.
+type Error = APIError
+
+func (f *APIError) Error() string {
+	if f.Endpoint == "" {
+		return f.Message
+	}
+	return fmt.Sprintf("itembase: %s %s: %d %s", f.Method, f.Endpoint, f.StatusCode, f.Message)
+}
+
+// Is reports whether the error belongs to the class of target, one of
+// ErrUnauthorized, ErrRateLimited or ErrNotFound.
+func (f *APIError) Is(target error) bool {
+	switch target {
+	case ErrUnauthorized:
+		return f.StatusCode == http.StatusUnauthorized || f.StatusCode == http.StatusForbidden
+	case ErrRateLimited:
+		return f.StatusCode == http.StatusTooManyRequests
+	case ErrNotFound:
+		return f.StatusCode == http.StatusNotFound
+	}
+	return false
+}
+
+// IsRetryable reports whether a call that failed with err may succeed when
+// repeated unchanged: rate limiting, server errors, timeouts and dropped
+// connections are retryable, while authorization, client and decoding errors
+// and cancelled contexts are not.
+func IsRetryable(err error) bool {
+	if err == nil {
+		return false
+	}
+
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+
+	if errors.Is(err, ErrDecode) {
+		return false
+	}
+
+	var apiErr *APIError
+	if errors.As(err, &apiErr) {
+		switch apiErr.StatusCode {
+		case http.StatusRequestTimeout,
+			http.StatusTooManyRequests,
+			http.StatusInternalServerError,
+			http.StatusBadGateway,
+			http.StatusServiceUnavailable,
+			http.StatusGatewayTimeout:
+			return true
+		}
+		return false
+	}
+
+	if errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, io.EOF) {
+		return true
+	}
+
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return true
+	}
+
+	var dnsErr *net.DNSError
+	if errors.As(err, &dnsErr) {
+		return dnsErr.IsTemporary || dnsErr.IsTimeout
+	}
+
+	// dial, read and write failures such as refused or reset connections
+	var opErr *net.OpError
+	return errors.As(err, &opErr)
+}
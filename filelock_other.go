@@ -0,0 +1,19 @@
+//go:build !unix
+
+package itembase
+
+import (
+	"sync"
+)
+
+// fileLocks serializes access to token files within the process on platforms
+// without flock. Other processes are not excluded.
+var fileLocks sync.Map
+
+// lockFile locks the named lock file within the process and returns a
+// function releasing it. Shared locks are taken exclusively.
+func lockFile(name string, exclusive bool) (unlock func(), err error) {
+	mu, _ := fileLocks.LoadOrStore(name, new(sync.Mutex))
+	mu.(*sync.Mutex).Lock()
+	return mu.(*sync.Mutex).Unlock, nil
+}
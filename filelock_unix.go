@@ -0,0 +1,39 @@
+//go:build unix
+
+package itembase
+
+import (
+	"os"
+	"syscall"
+)
+
+// lockFile takes an advisory lock on the named lock file, creating it if
+// needed, and returns a function releasing it. The lock is exclusive if
+// exclusive is set and shared otherwise.
+func lockFile(name string, exclusive bool) (unlock func(), err error) {
+	f, err := os.OpenFile(name, os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, err
+	}
+
+	how := syscall.LOCK_SH
+	if exclusive {
+		how = syscall.LOCK_EX
+	}
+
+	for {
+		err = syscall.Flock(int(f.Fd()), how)
+		if err != syscall.EINTR {
+			break
+		}
+	}
+	if err != nil {
+		f.Close()
+		return nil, &os.PathError{Op: "flock", Path: name, Err: err}
+	}
+
+	return func() {
+		syscall.Flock(int(f.Fd()), syscall.LOCK_UN)
+		f.Close()
+	}, nil
+}
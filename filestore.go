@@ -0,0 +1,224 @@
+package itembase
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"golang.org/x/oauth2"
+)
+
+// A FileTokenStore persists the OAuth2 token of each user as a JSON file in a
+// directory. Writes are atomic and the directory is locked while tokens are
+// read or written, so several processes can share it. Token files are only
+// readable by their owner.
+//
+//	store, err := itembase.NewFileTokenStore("/var/lib/myapp/tokens")
+//	config.TokenHandler = store.Handler()
+type FileTokenStore struct {
+	dir string
+}
+
+// storedToken is the persisted form of an oauth2.Token. Unlike oauth2.Token
+// itself it keeps the granted scope, which is only available as an extra
+// field of the token response.
+type storedToken struct {
+	AccessToken  string    `json:"access_token"`
+	TokenType    string    `json:"token_type,omitempty"`
+	RefreshToken string    `json:"refresh_token,omitempty"`
+	Expiry       time.Time `json:"expiry"`
+	Scope        string    `json:"scope,omitempty"`
+}
+
+func newStoredToken(token *oauth2.Token) storedToken {
+	return storedToken{
+		AccessToken:  token.AccessToken,
+		TokenType:    token.TokenType,
+		RefreshToken: token.RefreshToken,
+		Expiry:       token.Expiry,
+		Scope:        grantedScope(token),
+	}
+}
+
+func (stored storedToken) token() *oauth2.Token {
+	token := &oauth2.Token{
+		AccessToken:  stored.AccessToken,
+		TokenType:    stored.TokenType,
+		RefreshToken: stored.RefreshToken,
+		Expiry:       stored.Expiry,
+	}
+	if stored.Scope != "" {
+		token = token.WithExtra(map[string]interface{}{"scope": stored.Scope})
+	}
+	return token
+}
+
+// grantedScope returns the space separated scopes granted with token, as
+// returned in the token response.
+func grantedScope(token *oauth2.Token) string {
+	scope, _ := token.Extra("scope").(string)
+	return scope
+}
+
+// NewFileTokenStore returns a FileTokenStore keeping its files in dir, which is
+// created if it does not exist.
+func NewFileTokenStore(dir string) (*FileTokenStore, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	return &FileTokenStore{dir: dir}, nil
+}
+
+// Handler returns the token handlers for use as Config.TokenHandler.
+func (s *FileTokenStore) Handler() ItembaseTokens {
+	return ItembaseTokens{
+		TokenLoader:  s.Load,
+		TokenSaver:   s.Save,
+		TokenLister:  s.List,
+		TokenDeleter: s.Delete,
+	}
+}
+
+// Delete removes the token stored for userID, if any. No file named after
+// the user is left in the directory.
+func (s *FileTokenStore) Delete(userID string) error {
+	name, err := s.path(userID)
+	if err != nil {
+		return err
+	}
+
+	unlock, err := s.lock(true)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	// earlier versions kept a lock file per user
+	for _, name := range []string{name, name + ".lock"} {
+		if err := os.Remove(name); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+	}
+	return nil
+}
+
+// List returns the IDs of all users with a stored token. The application
+// token is not listed.
+func (s *FileTokenStore) List() (userIDs []string, err error) {
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, entry := range entries {
+		name, ok := strings.CutSuffix(entry.Name(), ".json")
+		if !ok || entry.IsDir() {
+			continue
+		}
+		if userID, err := url.PathUnescape(name); err == nil && userID != AppTokenKey {
+			userIDs = append(userIDs, userID)
+		}
+	}
+	return userIDs, nil
+}
+
+// Load returns the token stored for userID, or nil if there is none.
+func (s *FileTokenStore) Load(userID string) (*oauth2.Token, error) {
+	name, err := s.path(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	unlock, err := s.lock(false)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	data, err := os.ReadFile(name)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var stored storedToken
+	if err := json.Unmarshal(data, &stored); err != nil {
+		return nil, fmt.Errorf("itembase: reading token of %s: %w", userID, err)
+	}
+	return stored.token(), nil
+}
+
+// Save stores token for userID, replacing any previous token.
+func (s *FileTokenStore) Save(userID string, token *oauth2.Token) error {
+	name, err := s.path(userID)
+	if err != nil {
+		return err
+	}
+
+	data, err := json.Marshal(newStoredToken(token))
+	if err != nil {
+		return err
+	}
+
+	unlock, err := s.lock(true)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	return writeFileAtomic(name, data)
+}
+
+// lock locks the directory of the store, exclusively if exclusive is set.
+func (s *FileTokenStore) lock(exclusive bool) (unlock func(), err error) {
+	return lockFile(filepath.Join(s.dir, lockFileName), exclusive)
+}
+
+// lockFileName is the name of the lock file of a FileTokenStore directory.
+// Without the .json suffix, it is never taken for a token file.
+const lockFileName = ".lock"
+
+// path returns the name of the token file of userID.
+func (s *FileTokenStore) path(userID string) (string, error) {
+	if userID == "" || userID == "." || userID == ".." {
+		return "", fmt.Errorf("itembase: invalid user ID %q", userID)
+	}
+	// colons, as in AppTokenKey, are not allowed in Windows file names
+	return filepath.Join(s.dir, strings.ReplaceAll(url.PathEscape(userID), ":", "%3A")+".json"), nil
+}
+
+// writeFileAtomic replaces the named file with data, by writing a temporary
+// file in the same directory and renaming it.
+func writeFileAtomic(name string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(name), filepath.Base(name)+".tmp*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if err := tmp.Chmod(0600); err != nil {
+		tmp.Close()
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), name)
+}
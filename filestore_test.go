@@ -0,0 +1,143 @@
+package itembase_test
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"golang.org/x/oauth2"
+	"gopkg.in/saasbuilders/itembase.v0"
+)
+
+func newTestFileStore(t *testing.T) (*itembase.FileTokenStore, string) {
+	t.Helper()
+
+	dir := filepath.Join(t.TempDir(), "tokens")
+	store, err := itembase.NewFileTokenStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return store, dir
+}
+
+// files returns the names of the files in dir.
+func files(t *testing.T, dir string) (names []string) {
+	t.Helper()
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, entry := range entries {
+		names = append(names, entry.Name())
+	}
+	return names
+}
+
+func TestFileTokenStore(t *testing.T) {
+	store, dir := newTestFileStore(t)
+
+	if token, err := store.Load(testUserID); token != nil || err != nil {
+		t.Fatalf("Load() of a missing user = %v, %v, want nil, nil", token, err)
+	}
+
+	expiry := time.Now().Add(time.Hour).Round(time.Second)
+	token := (&oauth2.Token{AccessToken: "a", RefreshToken: "r", Expiry: expiry}).
+		WithExtra(map[string]interface{}{"scope": "user.minimal connection.product"})
+	if err := store.Save(testUserID, token); err != nil {
+		t.Fatal(err)
+	}
+
+	loaded, err := store.Load(testUserID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if loaded.AccessToken != "a" || loaded.RefreshToken != "r" || !loaded.Expiry.Equal(expiry) || loaded.Extra("scope") != "user.minimal connection.product" {
+		t.Errorf("Load() = %+v, scope %v", loaded, loaded.Extra("scope"))
+	}
+
+	if runtime.GOOS != "windows" {
+		info, err := os.Stat(filepath.Join(dir, testUserID+".json"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if mode := info.Mode().Perm(); mode != 0600 {
+			t.Errorf("token file mode = %v, want 0600", mode)
+		}
+	}
+}
+
+func TestFileTokenStoreAtomicReplace(t *testing.T) {
+	store, dir := newTestFileStore(t)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(2)
+		go func(i int) {
+			defer wg.Done()
+			if err := store.Save(testUserID, &oauth2.Token{AccessToken: strings.Repeat("a", 1000*i+1)}); err != nil {
+				t.Error(err)
+			}
+		}(i)
+		go func() {
+			defer wg.Done()
+			// never a partly written file
+			if _, err := store.Load(testUserID); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	// no temporary files are left behind
+	for _, name := range files(t, dir) {
+		if name != testUserID+".json" && !strings.HasPrefix(name, ".") {
+			t.Errorf("file %q left in the directory", name)
+		}
+	}
+}
+
+func TestFileTokenStoreList(t *testing.T) {
+	store, dir := newTestFileStore(t)
+
+	userIDs := []string{"13ac2c74", "shop/1", "shop:2", "..x", "ünïcode", "a b%20"}
+	for _, userID := range append(userIDs, itembase.AppTokenKey) {
+		if err := store.Save(userID, &oauth2.Token{AccessToken: userID}); err != nil {
+			t.Fatalf("Save(%q): %v", userID, err)
+		}
+	}
+
+	listed, err := store.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(listed)
+	sort.Strings(userIDs)
+	if !reflect.DeepEqual(listed, userIDs) {
+		t.Errorf("List() = %q, want %q", listed, userIDs)
+	}
+	for _, userID := range listed {
+		if token, err := store.Load(userID); err != nil || token.AccessToken != userID {
+			t.Errorf("Load(%q) = %v, %v", userID, token, err)
+		}
+	}
+
+	for _, userID := range append(userIDs, itembase.AppTokenKey) {
+		if err := store.Delete(userID); err != nil {
+			t.Fatalf("Delete(%q): %v", userID, err)
+		}
+	}
+	// only the lock file of the directory is left
+	if names := files(t, dir); len(names) != 1 || strings.Contains(names[0], "json") {
+		t.Errorf("files left after Delete: %q", names)
+	}
+	if err := store.Delete(testUserID); err != nil {
+		t.Errorf("Delete() of a missing user: %v", err)
+	}
+}
@@ -0,0 +1,277 @@
+package itembase
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"golang.org/x/oauth2"
+)
+
+// ErrInvalidState is returned by the OAuth callback for a state parameter that
+// was not issued by the connect handler to the same browser, was already used
+// or has expired.
+var ErrInvalidState = errors.New("itembase: invalid or expired OAuth state")
+
+// OAuthState is what is remembered about an authorization request between the
+// redirect to itembase and the callback.
+type OAuthState struct {
+	State     string
+	CreatedAt time.Time
+
+	// Verifier is the PKCE code verifier of the request, empty unless
+	// Config.PKCE is set.
+	Verifier string
+}
+
+// A StateStore keeps the state of pending authorization requests. Use a
+// shared store, such as one backed by a database or cache, when callbacks may
+// be served by another process than the one that started the request.
+type StateStore interface {
+	// Save stores state under state.State.
+	Save(ctx context.Context, state OAuthState) error
+
+	// Take returns and removes the state stored under key, or returns
+	// ErrInvalidState if there is none. A state can be taken only once.
+	Take(ctx context.Context, key string) (OAuthState, error)
+}
+
+// A MemoryStateStore is a StateStore keeping states in memory for a limited
+// time.
+type MemoryStateStore struct {
+	ttl time.Duration
+
+	mu     sync.Mutex
+	states map[string]OAuthState
+}
+
+// NewMemoryStateStore returns a MemoryStateStore whose states expire after
+// ttl.
+func NewMemoryStateStore(ttl time.Duration) *MemoryStateStore {
+	return &MemoryStateStore{ttl: ttl, states: make(map[string]OAuthState)}
+}
+
+func (s *MemoryStateStore) Save(ctx context.Context, state OAuthState) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	for key, stored := range s.states {
+		if now.Sub(stored.CreatedAt) > s.ttl {
+			delete(s.states, key)
+		}
+	}
+
+	s.states[state.State] = state
+	return nil
+}
+
+func (s *MemoryStateStore) Take(ctx context.Context, key string) (OAuthState, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	state, ok := s.states[key]
+	if !ok {
+		return OAuthState{}, ErrInvalidState
+	}
+	delete(s.states, key)
+
+	if time.Since(state.CreatedAt) > s.ttl {
+		return OAuthState{}, ErrInvalidState
+	}
+	return state, nil
+}
+
+// An OAuthHandler connects shops to the application through the OAuth2
+// authorization code flow. Connect redirects the shop owner to itembase, and
+// Callback, served at Config.RedirectURL, verifies the state, exchanges the
+// code and saves the token of the shop's user through the TokenSaver. The
+// state is also set in a cookie by Connect, and Callback only accepts it from
+// the browser that started the flow.
+//
+//	oauth := itembase.NewOAuthHandler(itembase.New(config, nil))
+//	oauth.OnConnect = func(w http.ResponseWriter, r *http.Request, userID string, token *oauth2.Token) {
+//		http.Redirect(w, r, "/shops/"+userID, http.StatusFound)
+//	}
+//	http.HandleFunc("/connect", oauth.Connect)
+//	http.HandleFunc("/oauth/callback", oauth.Callback)
+type OAuthHandler struct {
+	Client Client
+
+	// States keeps pending authorization requests. NewOAuthHandler sets a
+	// MemoryStateStore with a ten minute lifetime.
+	States StateStore
+
+	// PKCE makes Connect send an S256 code challenge and Callback the
+	// matching verifier. NewOAuthHandler sets it to the Config.PKCE of
+	// clients created by New; set it yourself for other implementations of
+	// Client.
+	PKCE bool
+
+	// Cookie is the template of the cookie binding the state to the
+	// browser. Its Value and MaxAge are set by Connect, it is always
+	// HttpOnly, and Secure is set for requests received over TLS. An empty
+	// Name is replaced by "itembase_oauth_state" and an empty Path by "/".
+	// NewOAuthHandler sets SameSite to Lax, which keeps the cookie on the
+	// redirect back from itembase.
+	Cookie http.Cookie
+
+	// OnConnect is called after the token of a shop has been saved. If
+	// nil, a short confirmation is written.
+	OnConnect func(w http.ResponseWriter, r *http.Request, userID string, token *oauth2.Token)
+
+	// OnError is called when the flow fails. If nil, a plain error is
+	// written, with status 400 for invalid or denied requests, 500 if the
+	// token could not be saved and 502 for other failures talking to
+	// itembase.
+	OnError func(w http.ResponseWriter, r *http.Request, err error)
+}
+
+// stateLifetime is the lifetime of the states of NewOAuthHandler, and of the
+// cookies set by Connect.
+const stateLifetime = 10 * time.Minute
+
+// NewOAuthHandler returns an OAuthHandler for c.
+func NewOAuthHandler(c Client) *OAuthHandler {
+	h := &OAuthHandler{
+		Client: c,
+		States: NewMemoryStateStore(stateLifetime),
+		Cookie: http.Cookie{SameSite: http.SameSiteLaxMode},
+	}
+	if impl, ok := c.(*client); ok {
+		h.PKCE = impl.options.PKCE
+	}
+	return h
+}
+
+// Connect redirects to the itembase consent page.
+func (h *OAuthHandler) Connect(w http.ResponseWriter, r *http.Request) {
+	key, err := newState()
+	if err != nil {
+		h.fail(w, r, err)
+		return
+	}
+
+	opts := []oauth2.AuthCodeOption{oauth2.AccessTypeOffline}
+	var verifier string
+	if h.PKCE {
+		if verifier, err = NewPKCEVerifier(); err != nil {
+			h.fail(w, r, err)
+			return
+		}
+		opts = append(opts, PKCEChallenge(verifier)...)
+	}
+
+	state := OAuthState{State: key, CreatedAt: time.Now(), Verifier: verifier}
+	if err := h.States.Save(r.Context(), state); err != nil {
+		h.fail(w, r, err)
+		return
+	}
+
+	cookie := h.cookie(r)
+	cookie.Value = key
+	cookie.MaxAge = int(stateLifetime / time.Second)
+	http.SetCookie(w, &cookie)
+
+	http.Redirect(w, r, h.Client.AuthCodeURL(key, opts...), http.StatusFound)
+}
+
+// Callback completes the authorization started by Connect.
+func (h *OAuthHandler) Callback(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	query := r.URL.Query()
+
+	// the state is only valid in the browser it was issued to, so a
+	// callback URL of someone else's authorization cannot be slipped in
+	cookie := h.cookie(r)
+	issued, _ := r.Cookie(cookie.Name)
+	cookie.MaxAge = -1
+	http.SetCookie(w, &cookie)
+
+	key := query.Get("state")
+	if issued == nil || key == "" || subtle.ConstantTimeCompare([]byte(issued.Value), []byte(key)) != 1 {
+		h.fail(w, r, fmt.Errorf("%w: not issued to this browser", ErrInvalidState))
+		return
+	}
+
+	state, err := h.States.Take(ctx, key)
+	if err != nil {
+		h.fail(w, r, err)
+		return
+	}
+
+	if denied := query.Get("error"); denied != "" {
+		h.fail(w, r, fmt.Errorf("%w: %s", ErrPermissionRequired, denied))
+		return
+	}
+
+	code := query.Get("code")
+	if code == "" {
+		h.fail(w, r, fmt.Errorf("%w: no authorization code", ErrPermissionRequired))
+		return
+	}
+
+	var opts []oauth2.AuthCodeOption
+	if state.Verifier != "" {
+		opts = append(opts, PKCEVerifier(state.Verifier))
+	}
+
+	token, err := h.Client.HandleOAuthCodeContext(ctx, code, opts...)
+	if err != nil {
+		h.fail(w, r, err)
+		return
+	}
+
+	userID, err := h.Client.GetUserIDForTokenContext(ctx, token)
+	if err != nil {
+		h.fail(w, r, err)
+		return
+	}
+
+	if err := h.Client.SaveToken(userID, token); err != nil {
+		h.fail(w, r, fmt.Errorf("%w: %w", ErrTokenSave, err))
+		return
+	}
+
+	if h.OnConnect != nil {
+		h.OnConnect(w, r, userID, token)
+		return
+	}
+	fmt.Fprintln(w, "Shop connected.")
+}
+
+// cookie returns the state cookie template for r, with defaults applied.
+func (h *OAuthHandler) cookie(r *http.Request) http.Cookie {
+	cookie := h.Cookie
+	if cookie.Name == "" {
+		cookie.Name = "itembase_oauth_state"
+	}
+	if cookie.Path == "" {
+		cookie.Path = "/"
+	}
+	cookie.HttpOnly = true
+	cookie.Secure = cookie.Secure || r.TLS != nil
+	return cookie
+}
+
+func (h *OAuthHandler) fail(w http.ResponseWriter, r *http.Request, err error) {
+	if h.OnError != nil {
+		h.OnError(w, r, err)
+		return
+	}
+
+	switch {
+	case errors.Is(err, ErrInvalidState), errors.Is(err, ErrPermissionRequired):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	case errors.Is(err, ErrTokenExchange):
+		http.Error(w, ErrTokenExchange.Error(), http.StatusBadRequest)
+	case errors.Is(err, ErrTokenSave):
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+	default:
+		http.Error(w, http.StatusText(http.StatusBadGateway), http.StatusBadGateway)
+	}
+}
@@ -0,0 +1,107 @@
+package itembase_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"gopkg.in/saasbuilders/itembase.v0"
+	"gopkg.in/saasbuilders/itembase.v0/itembasetest"
+)
+
+// newTestOAuthHandler starts a fake itembase with one user, and returns an
+// OAuthHandler for config, as changed by configure, with the store saving its
+// tokens.
+func newTestOAuthHandler(t *testing.T, configure func(*itembase.Config)) (*itembasetest.Server, *itembase.OAuthHandler, *memoryTokens) {
+	t.Helper()
+
+	server := itembasetest.NewServer()
+	t.Cleanup(server.Close)
+	server.AddUser(itembase.User{UUID: testUserID})
+
+	store := newMemoryTokens()
+	config := server.Config()
+	config.RedirectURL = "https://app.example/oauth/callback"
+	config.TokenHandler = store.Handler()
+	if configure != nil {
+		configure(&config)
+	}
+
+	return server, itembase.NewOAuthHandler(itembase.New(config, nil)), store
+}
+
+// connect starts an authorization through h in a new browser, lets the fake
+// itembase grant it, and returns the query of the callback along with the
+// cookies of the browser. A non-nil response is the failed authorization.
+func connect(t *testing.T, server *itembasetest.Server, h *itembase.OAuthHandler) (url.Values, []*http.Cookie, *http.Response) {
+	t.Helper()
+
+	connected := httptest.NewRecorder()
+	h.Connect(connected, httptest.NewRequest("GET", "https://app.example/connect", nil))
+	if connected.Code != http.StatusFound {
+		t.Fatalf("Connect: status %d: %s", connected.Code, connected.Body)
+	}
+
+	browser := server.Client()
+	browser.CheckRedirect = func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }
+	response, err := browser.Get(connected.Header().Get("Location"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	response.Body.Close()
+	if response.StatusCode != http.StatusFound {
+		return nil, nil, response
+	}
+
+	redirect, err := url.Parse(response.Header.Get("Location"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return redirect.Query(), connected.Result().Cookies(), nil
+}
+
+func callback(h *itembase.OAuthHandler, query url.Values, cookies []*http.Cookie) *httptest.ResponseRecorder {
+	request := httptest.NewRequest("GET", "https://app.example/oauth/callback?"+query.Encode(), nil)
+	for _, cookie := range cookies {
+		request.AddCookie(cookie)
+	}
+
+	recorder := httptest.NewRecorder()
+	h.Callback(recorder, request)
+	return recorder
+}
+
+func TestOAuthHandler(t *testing.T) {
+	server, h, store := newTestOAuthHandler(t, nil)
+
+	query, cookies, _ := connect(t, server, h)
+	if response := callback(h, query, cookies); response.Code != http.StatusOK {
+		t.Fatalf("Callback: status %d: %s", response.Code, response.Body)
+	}
+	if store.get(testUserID) == nil {
+		t.Error("token not saved")
+	}
+
+	// states are used once
+	if response := callback(h, query, cookies); response.Code != http.StatusBadRequest {
+		t.Errorf("replayed Callback: status %d, want 400", response.Code)
+	}
+}
+
+func TestOAuthHandlerOtherBrowser(t *testing.T) {
+	server, h, store := newTestOAuthHandler(t, nil)
+
+	// the attacker's authorization, completed in the victim's browser
+	attackerQuery, _, _ := connect(t, server, h)
+	_, victimCookies, _ := connect(t, server, h)
+
+	for name, cookies := range map[string][]*http.Cookie{"no cookie": nil, "other cookie": victimCookies} {
+		if response := callback(h, attackerQuery, cookies); response.Code != http.StatusBadRequest {
+			t.Errorf("%s: status %d, want 400", name, response.Code)
+		}
+	}
+	if store.get(testUserID) != nil {
+		t.Error("token saved from another browser")
+	}
+}
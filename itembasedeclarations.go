@@ -2,7 +2,6 @@ package itembase
 
 import (
 	"encoding/json"
-	"log"
 	"strings"
 	"time"
 )
@@ -42,6 +41,11 @@ type Profile struct {
 	URL               string     `json:"url,omitempty"`
 }
 
+func (profile Profile) EntityID() string    { return profile.ID.String() }
+func (profile Profile) Created() *time.Time { return profile.CreatedAt }
+func (profile Profile) Updated() *time.Time { return profile.UpdatedAt }
+func (profile Profile) Source() string      { return profile.SourceID }
+
 // An Address represents a mailing address model from the itembase API.
 type Address struct {
 	City    string `json:"city,omitempty"`
@@ -97,6 +101,11 @@ type Buyer struct {
 	URL               string     `json:"url,omitempty"`
 }
 
+func (buyer Buyer) EntityID() string    { return buyer.ID.String() }
+func (buyer Buyer) Created() *time.Time { return buyer.CreatedAt }
+func (buyer Buyer) Updated() *time.Time { return buyer.UpdatedAt }
+func (buyer Buyer) Source() string      { return buyer.SourceID }
+
 // GetEmail returns an Email for a Profile
 func (buyer *Buyer) GetEmail() string {
 	if len(buyer.Contact.Emails) > 0 {
@@ -192,6 +201,11 @@ type Product struct {
 	Variants         []interface{}    `json:"variants,omitempty"`
 }
 
+func (product Product) EntityID() string    { return product.ID.String() }
+func (product Product) Created() *time.Time { return product.CreatedAt }
+func (product Product) Updated() *time.Time { return product.UpdatedAt }
+func (product Product) Source() string      { return product.SourceID }
+
 func (product *Product) InStock() bool {
 	return product.StockInformation.InStock
 }
@@ -269,6 +283,11 @@ type Transaction struct {
 	UpdatedAt         *time.Time    `json:"updated_at,omitempty"`
 }
 
+func (t Transaction) EntityID() string    { return t.ID.String() }
+func (t Transaction) Created() *time.Time { return t.CreatedAt }
+func (t Transaction) Updated() *time.Time { return t.UpdatedAt }
+func (t Transaction) Source() string      { return t.SourceID }
+
 func (t *Transaction) Completed() bool {
 	if t.Status.Global == "completed" {
 		return true
@@ -284,288 +303,260 @@ type ItembaseResponse struct {
 	NumDocumentsReturned int           `json:"num_documents_returned"`
 }
 
-// Transactions is a container for pagination of Transaction entities.
+// Transactions is a container for pagination of Transaction entities. It
+// behaves like a Collection[Transaction] keeping its documents in the
+// Transactions field.
 type Transactions struct {
 	Transactions []Transaction `json:"documents"`
+
+	index index[Transaction]
 }
 
 func (transactions *Transactions) Add(transaction interface{}) error {
+	return transactions.index.add(&transactions.Transactions, transaction)
+}
 
-	var newTransaction Transaction
-	err := ConvertTo(transaction, &newTransaction)
-	if err != nil {
-		log.Println("Error when converting", "error", err)
-		return err
-	}
-	if !transactions.Exists(newTransaction) {
-		transactions.Transactions = append(transactions.Transactions, newTransaction)
-	}
-	return nil
+func (transactions *Transactions) Upsert(transaction Transaction) bool {
+	return transactions.index.upsert(&transactions.Transactions, transaction)
+}
 
+func (transactions *Transactions) Get(id string) (Transaction, bool) {
+	return transactions.index.get(&transactions.Transactions, id)
 }
 
 func (transactions *Transactions) Exists(searchTransaction Transaction) bool {
-
-	for _, transaction := range transactions.Transactions {
-		if searchTransaction.ID == transaction.ID {
-			log.Println("Transaction already exists in collection")
-			return true
-		}
-	}
-	return false
+	_, ok := transactions.Get(searchTransaction.EntityID())
+	return ok
 }
 
 func (transactions *Transactions) Count() int {
+	transactions.index.reindex(&transactions.Transactions)
 	return len(transactions.Transactions)
 }
 
 // Return date of heighest Created At transaction
 func (transactions *Transactions) MaxCreatedAt() time.Time {
-	var maxCreatedAt time.Time
-
-	for _, transaction := range transactions.Transactions {
-		if transaction.CreatedAt.After(maxCreatedAt) {
-			maxCreatedAt = *transaction.CreatedAt
-		}
-	}
-
-	return maxCreatedAt
+	transactions.index.reindex(&transactions.Transactions)
+	return transactions.index.maxCreatedAt
 }
 
 // Return date of heighest Updated At transaction
 func (transactions *Transactions) MaxUpdatedAt() time.Time {
-	var maxUpdatedAt time.Time
+	transactions.index.reindex(&transactions.Transactions)
+	return transactions.index.maxUpdatedAt
+}
 
-	for _, transaction := range transactions.Transactions {
-		if transaction.UpdatedAt != nil && transaction.UpdatedAt.After(maxUpdatedAt) {
-			maxUpdatedAt = *transaction.UpdatedAt
-		}
-	}
+func (transactions *Transactions) Filter(keep func(*Transaction) bool) Transactions {
+	filtered := transactions.index.filter(&transactions.Transactions, keep)
+	return Transactions{Transactions: filtered.Documents}
+}
 
-	return maxUpdatedAt
+func (transactions *Transactions) ByShop(shopID string) Transactions {
+	return transactions.Filter(fromShop[Transaction](shopID))
 }
 
 // Return only completed transactions
-func (transactions *Transactions) Completed() (filteredTransactions Transactions) {
-	for _, transaction := range transactions.Transactions {
-		if transaction.Completed() {
-			filteredTransactions.Add(transaction)
-		}
-	}
+func (transactions *Transactions) Completed() Transactions {
+	return transactions.Filter((*Transaction).Completed)
+}
 
-	return
+func (transactions *Transactions) UnmarshalJSON(data []byte) error {
+	documents, err := unmarshalDocuments[Transaction](data)
+	if err != nil {
+		return err
+	}
+	transactions.Transactions = documents
+	transactions.index.reset(&transactions.Transactions)
+	return nil
 }
 
-// Profiles is a container for pagination of Profile entities.
+// Profiles is a container for pagination of Profile entities. It behaves like
+// a Collection[Profile] keeping its documents in the Profiles field.
 type Profiles struct {
 	Profiles []Profile `json:"documents"`
+
+	index index[Profile]
 }
 
 func (profiles *Profiles) Add(profile interface{}) error {
+	return profiles.index.add(&profiles.Profiles, profile)
+}
 
-	var newProfile Profile
-	err := ConvertTo(profile, &newProfile)
-	if err != nil {
-		log.Println("Error when converting", "error", err)
-		return err
-	}
-	if !profiles.Exists(newProfile) {
-		profiles.Profiles = append(profiles.Profiles, newProfile)
-	}
-	return nil
+func (profiles *Profiles) Upsert(profile Profile) bool {
+	return profiles.index.upsert(&profiles.Profiles, profile)
+}
 
+func (profiles *Profiles) Get(id string) (Profile, bool) {
+	return profiles.index.get(&profiles.Profiles, id)
 }
 
 func (profiles *Profiles) Exists(searchProfile Profile) bool {
-
-	for _, profile := range profiles.Profiles {
-		if searchProfile.ID == profile.ID {
-			log.Println("Profile already exists in collection")
-			return true
-		}
-	}
-	return false
+	_, ok := profiles.Get(searchProfile.EntityID())
+	return ok
 }
 
 func (profiles *Profiles) Count() int {
+	profiles.index.reindex(&profiles.Profiles)
 	return len(profiles.Profiles)
 }
 
 // Return date of heighest Created At profile
 func (profiles *Profiles) MaxCreatedAt() time.Time {
-	var maxCreatedAt time.Time
-
-	for _, profile := range profiles.Profiles {
-		if profile.CreatedAt.After(maxCreatedAt) {
-			maxCreatedAt = *profile.CreatedAt
-		}
-	}
-
-	return maxCreatedAt
+	profiles.index.reindex(&profiles.Profiles)
+	return profiles.index.maxCreatedAt
 }
 
 // Return date of heighest Updated At profile
 func (profiles *Profiles) MaxUpdatedAt() time.Time {
-	var maxUpdatedAt time.Time
+	profiles.index.reindex(&profiles.Profiles)
+	return profiles.index.maxUpdatedAt
+}
 
-	for _, profile := range profiles.Profiles {
-		if profile.UpdatedAt != nil && profile.UpdatedAt.After(maxUpdatedAt) {
-			maxUpdatedAt = *profile.UpdatedAt
-		}
-	}
+func (profiles *Profiles) Filter(keep func(*Profile) bool) Profiles {
+	filtered := profiles.index.filter(&profiles.Profiles, keep)
+	return Profiles{Profiles: filtered.Documents}
+}
+
+func (profiles *Profiles) ByShop(shopID string) Profiles {
+	return profiles.Filter(fromShop[Profile](shopID))
+}
 
-	return maxUpdatedAt
+func (profiles *Profiles) UnmarshalJSON(data []byte) error {
+	documents, err := unmarshalDocuments[Profile](data)
+	if err != nil {
+		return err
+	}
+	profiles.Profiles = documents
+	profiles.index.reset(&profiles.Profiles)
+	return nil
 }
 
-// Products is a container for pagination of Product entities.
+// Products is a container for pagination of Product entities. It behaves like
+// a Collection[Product] keeping its documents in the Products field.
 type Products struct {
 	Products []Product `json:"documents"`
+
+	index index[Product]
 }
 
 func (products *Products) Add(product interface{}) error {
+	return products.index.add(&products.Products, product)
+}
 
-	var newProduct Product
-	err := ConvertTo(product, &newProduct)
-	if err != nil {
-		log.Println("Error when converting", "error", err)
-		return err
-	}
-	if !products.Exists(newProduct) {
-		products.Products = append(products.Products, newProduct)
-	}
-	return nil
+func (products *Products) Upsert(product Product) bool {
+	return products.index.upsert(&products.Products, product)
+}
 
+func (products *Products) Get(id string) (Product, bool) {
+	return products.index.get(&products.Products, id)
 }
 
 func (products *Products) Exists(searchProduct Product) bool {
-
-	for _, product := range products.Products {
-		if searchProduct.ID == product.ID {
-			log.Println("Product already exists in collection", searchProduct.ID, product.ID)
-			return true
-		}
-	}
-	return false
+	_, ok := products.Get(searchProduct.EntityID())
+	return ok
 }
 
 func (products *Products) Count() int {
+	products.index.reindex(&products.Products)
 	return len(products.Products)
 }
 
 // Return date of heighest Created At product
 func (products *Products) MaxCreatedAt() time.Time {
-	var maxCreatedAt time.Time
-
-	for _, product := range products.Products {
-		if product.CreatedAt.After(maxCreatedAt) {
-			maxCreatedAt = *product.CreatedAt
-		}
-	}
-
-	return maxCreatedAt
+	products.index.reindex(&products.Products)
+	return products.index.maxCreatedAt
 }
 
 // Return date of heighest Updated At product
 func (products *Products) MaxUpdatedAt() time.Time {
-	var maxUpdatedAt time.Time
-
-	for _, product := range products.Products {
-		if product.UpdatedAt != nil && product.UpdatedAt.After(maxUpdatedAt) {
-			maxUpdatedAt = *product.UpdatedAt
-		}
-	}
+	products.index.reindex(&products.Products)
+	return products.index.maxUpdatedAt
+}
 
-	return maxUpdatedAt
+func (products *Products) Filter(keep func(*Product) bool) Products {
+	filtered := products.index.filter(&products.Products, keep)
+	return Products{Products: filtered.Documents}
 }
 
-func (products *Products) InStock() (filteredProducts Products) {
-	for _, product := range products.Products {
-		if product.InStock() {
-			filteredProducts.Add(product)
-		}
-	}
-	return
+// Return only products in stock
+func (products *Products) InStock() Products {
+	return products.Filter((*Product).InStock)
 }
 
 // Get Products based on shopID
-func (products *Products) ByShop(shopID string) (filteredProducts Products) {
-	for _, product := range products.Products {
-		if product.SourceID == shopID {
-			filteredProducts.Add(product)
-		}
+func (products *Products) ByShop(shopID string) Products {
+	return products.Filter(fromShop[Product](shopID))
+}
+
+func (products *Products) UnmarshalJSON(data []byte) error {
+	documents, err := unmarshalDocuments[Product](data)
+	if err != nil {
+		return err
 	}
-	return
+	products.Products = documents
+	products.index.reset(&products.Products)
+	return nil
 }
 
-// Buyers is a container for pagination of Buyer entities.
+// Buyers is a container for pagination of Buyer entities. It behaves like a
+// Collection[Buyer] keeping its documents in the Buyers field.
 type Buyers struct {
 	Buyers []Buyer `json:"documents"`
+
+	index index[Buyer]
 }
 
 func (buyers *Buyers) Add(buyer interface{}) error {
+	return buyers.index.add(&buyers.Buyers, buyer)
+}
 
-	var newBuyer Buyer
-	err := ConvertTo(buyer, &newBuyer)
-	if err != nil {
-		log.Println("Error when converting", "error", err)
-		return err
-	}
-	if !buyers.Exists(newBuyer) {
-		buyers.Buyers = append(buyers.Buyers, newBuyer)
-	}
-	return nil
+func (buyers *Buyers) Upsert(buyer Buyer) bool {
+	return buyers.index.upsert(&buyers.Buyers, buyer)
+}
 
+func (buyers *Buyers) Get(id string) (Buyer, bool) {
+	return buyers.index.get(&buyers.Buyers, id)
 }
 
 func (buyers *Buyers) Exists(searchBuyer Buyer) bool {
-
-	for _, buyer := range buyers.Buyers {
-		if searchBuyer.ID == buyer.ID {
-			log.Println("Buyer already exists in collection")
-			return true
-		}
-	}
-	return false
+	_, ok := buyers.Get(searchBuyer.EntityID())
+	return ok
 }
 
 func (buyers *Buyers) Count() int {
+	buyers.index.reindex(&buyers.Buyers)
 	return len(buyers.Buyers)
 }
 
 // Return date of heighest Created At buyer
 func (buyers *Buyers) MaxCreatedAt() time.Time {
-	var maxCreatedAt time.Time
-
-	for _, buyer := range buyers.Buyers {
-		if buyer.CreatedAt.After(maxCreatedAt) {
-			maxCreatedAt = *buyer.CreatedAt
-		}
-	}
-
-	return maxCreatedAt
+	buyers.index.reindex(&buyers.Buyers)
+	return buyers.index.maxCreatedAt
 }
 
 // Return date of heighest Updated At buyer
 func (buyers *Buyers) MaxUpdatedAt() time.Time {
-	var maxUpdatedAt time.Time
-
-	for _, buyer := range buyers.Buyers {
-		if buyer.UpdatedAt != nil && buyer.UpdatedAt.After(maxUpdatedAt) {
-			maxUpdatedAt = *buyer.UpdatedAt
-		}
-	}
+	buyers.index.reindex(&buyers.Buyers)
+	return buyers.index.maxUpdatedAt
+}
 
-	return maxUpdatedAt
+func (buyers *Buyers) Filter(keep func(*Buyer) bool) Buyers {
+	filtered := buyers.index.filter(&buyers.Buyers, keep)
+	return Buyers{Buyers: filtered.Documents}
 }
 
-func (buyers *Buyers) ByShop(shopID string) (filteredBuyers Buyers) {
+func (buyers *Buyers) ByShop(shopID string) Buyers {
+	return buyers.Filter(fromShop[Buyer](shopID))
+}
 
-	for _, buyer := range buyers.Buyers {
-		if buyer.SourceID == shopID {
-			filteredBuyers.Add(buyer)
-		}
+func (buyers *Buyers) UnmarshalJSON(data []byte) error {
+	documents, err := unmarshalDocuments[Buyer](data)
+	if err != nil {
+		return err
 	}
-	return
+	buyers.Buyers = documents
+	buyers.index.reset(&buyers.Buyers)
+	return nil
 }
 
 // A User represents a user entity from the itembase API, such as returned from
@@ -586,13 +577,11 @@ func ConvertTo(inputInterface, outputType interface{}) error {
 
 	jsonBLOB, err := json.Marshal(inputInterface)
 	if err != nil {
-		log.Fatal(err)
 		return err
 	}
 
 	err = json.Unmarshal(jsonBLOB, &outputType)
 	if err != nil {
-		log.Fatal(err)
 		return err
 	}
 
@@ -0,0 +1,860 @@
+// Package itembasetest provides an in-process fake of the itembase API for
+// tests.
+//
+// A Server emulates the OAuth2 authorization and token endpoints, the "me"
+// endpoint, the /users/{id}/transactions, products, buyers and profiles
+// collections including pagination and date filters, and the activation
+// endpoint. Seed it with Go values or JSON fixtures, then point a Client at it
+// through its Config:
+//
+//	server := itembasetest.NewServer()
+//	defer server.Close()
+//
+//	server.Seed(itembasetest.Fixture{
+//		User:         itembase.User{UUID: "13ac2c74"},
+//		Transactions: []itembase.Transaction{{ID: "6ee2e2d9"}},
+//	})
+//
+//	storeRef, err := itembase.New(server.Config(), nil).ForUser(ctx, "13ac2c74")
+package itembasetest
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/oauth2"
+	"gopkg.in/saasbuilders/itembase.v0"
+)
+
+// Client credentials accepted by a Server unless changed.
+const (
+	ClientID     = "itembasetest-client"
+	ClientSecret = "itembasetest-secret"
+)
+
+// DefaultPageSize is the number of documents a Server returns per page when a
+// request carries no document_limit.
+const DefaultPageSize = 50
+
+// The collections served under /users/{id}.
+var collections = []string{"transactions", "products", "buyers", "profiles"}
+
+// A Server is a fake itembase API backed by an httptest.Server.
+type Server struct {
+	*httptest.Server
+
+	// ClientID and ClientSecret are the credentials the token endpoint
+	// accepts. They default to the package constants.
+	ClientID     string
+	ClientSecret string
+
+	// PageSize is the number of documents returned per page when a request
+	// carries no document_limit.
+	PageSize int
+
+	// TokenLifetime is the lifetime of issued access tokens.
+	TokenLifetime time.Duration
+
+	// RequirePKCE makes the authorization endpoint reject requests without
+	// an S256 code challenge. Challenges sent without it are enforced all
+	// the same, and codes issued with a challenge may be exchanged without
+	// the client secret, as by a public client.
+	RequirePKCE bool
+
+	mu            sync.Mutex
+	users         map[string]*user
+	accessTokens  map[string]string
+	refreshTokens map[string]string
+	publicTokens  map[string]bool
+	scopes        map[string]string
+	appTokens     map[string]bool
+	codes         map[string]authorization
+	activations   int
+}
+
+// authorization is an issued authorization code.
+type authorization struct {
+	userID    string
+	challenge string
+	scope     string
+}
+
+type user struct {
+	// granted are the scopes the user granted through the authorization
+	// endpoint so far.
+	granted map[string]bool
+
+	profile   itembase.User
+	documents map[string][]document
+}
+
+// document is a seeded entity, kept as JSON along with the fields needed for
+// lookups and filtering.
+type document struct {
+	id        string
+	createdAt *time.Time
+	updatedAt *time.Time
+	raw       json.RawMessage
+}
+
+// NewServer starts and returns a new, empty Server. The caller should call
+// Close when finished, to shut it down.
+func NewServer() *Server {
+	s := &Server{
+		ClientID:      ClientID,
+		ClientSecret:  ClientSecret,
+		PageSize:      DefaultPageSize,
+		TokenLifetime: time.Hour,
+		users:         make(map[string]*user),
+		accessTokens:  make(map[string]string),
+		refreshTokens: make(map[string]string),
+		publicTokens:  make(map[string]bool),
+		scopes:        make(map[string]string),
+		appTokens:     make(map[string]bool),
+		codes:         make(map[string]authorization),
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/oauth/v2/auth", s.handleAuth)
+	mux.HandleFunc("/oauth/v2/token", s.handleToken)
+	mux.HandleFunc("/v1/me", s.handleMe)
+	mux.HandleFunc("/v1/users/", s.handleUsers)
+	mux.HandleFunc("/activate", s.handleActivate)
+	mux.HandleFunc("/oauth/v2/revoke", s.handleRevoke)
+
+	s.Server = httptest.NewServer(mux)
+	return s
+}
+
+// Endpoints returns the itembase endpoints served by s.
+func (s *Server) Endpoints() itembase.Endpoints {
+	return itembase.Endpoints{
+		AuthURL:       s.URL + "/oauth/v2/auth",
+		TokenURL:      s.URL + "/oauth/v2/token",
+		MeURL:         s.URL + "/v1/me",
+		APIRoot:       s.URL + "/v1",
+		ActivationURL: s.URL,
+		RevocationURL: s.URL + "/oauth/v2/revoke",
+	}
+}
+
+// Config returns a Config pointing at s, with credentials s accepts and a
+// TokenHandler that hands out tokens for every seeded user. Tokens are kept
+// in memory, and its TokenLister lists the users holding one, leaving out the
+// application token.
+func (s *Server) Config() itembase.Config {
+	tokens := make(map[string]*oauth2.Token)
+	var mu sync.Mutex
+
+	return itembase.Config{
+		ClientID:     s.ClientID,
+		ClientSecret: s.ClientSecret,
+		Endpoints:    s.Endpoints(),
+		HTTPClient:   s.Client(),
+		TokenHandler: itembase.ItembaseTokens{
+			TokenLoader: func(userID string) (*oauth2.Token, error) {
+				mu.Lock()
+				defer mu.Unlock()
+				if token, ok := tokens[userID]; ok {
+					return token, nil
+				}
+				token, err := s.Token(userID)
+				if err == nil {
+					tokens[userID] = token
+				}
+				return token, err
+			},
+			TokenSaver: func(userID string, token *oauth2.Token) error {
+				mu.Lock()
+				defer mu.Unlock()
+				tokens[userID] = token
+				return nil
+			},
+			TokenLister: func() ([]string, error) {
+				mu.Lock()
+				defer mu.Unlock()
+				userIDs := make([]string, 0, len(tokens))
+				for userID := range tokens {
+					if userID != itembase.AppTokenKey {
+						userIDs = append(userIDs, userID)
+					}
+				}
+				return userIDs, nil
+			},
+			TokenDeleter: func(userID string) error {
+				mu.Lock()
+				defer mu.Unlock()
+				delete(tokens, userID)
+				return nil
+			},
+		},
+	}
+}
+
+// A Fixture holds a user and their documents to seed a Server with. Fixtures
+// can be built from Go values or decoded from JSON in the shape
+//
+//	{"user": {...}, "transactions": [...], "products": [...], "buyers": [...], "profiles": [...]}
+type Fixture struct {
+	User         itembase.User          `json:"user"`
+	Transactions []itembase.Transaction `json:"transactions,omitempty"`
+	Products     []itembase.Product     `json:"products,omitempty"`
+	Buyers       []itembase.Buyer       `json:"buyers,omitempty"`
+	Profiles     []itembase.Profile     `json:"profiles,omitempty"`
+}
+
+// Seed adds the fixture's user, if not known yet, and their documents.
+func (s *Server) Seed(fixture Fixture) error {
+	if fixture.User.UUID == "" {
+		return fmt.Errorf("itembasetest: fixture user has no uuid")
+	}
+
+	s.AddUser(fixture.User)
+
+	add := func(collection string, n int, document func(int) interface{}) error {
+		for i := 0; i < n; i++ {
+			if err := s.AddDocuments(fixture.User.UUID, collection, document(i)); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	if err := add("transactions", len(fixture.Transactions), func(i int) interface{} { return fixture.Transactions[i] }); err != nil {
+		return err
+	}
+	if err := add("products", len(fixture.Products), func(i int) interface{} { return fixture.Products[i] }); err != nil {
+		return err
+	}
+	if err := add("buyers", len(fixture.Buyers), func(i int) interface{} { return fixture.Buyers[i] }); err != nil {
+		return err
+	}
+	return add("profiles", len(fixture.Profiles), func(i int) interface{} { return fixture.Profiles[i] })
+}
+
+// LoadFixture decodes a JSON Fixture from r and seeds s with it.
+func (s *Server) LoadFixture(r io.Reader) error {
+	var fixture Fixture
+	if err := json.NewDecoder(r).Decode(&fixture); err != nil {
+		return fmt.Errorf("itembasetest: decoding fixture: %w", err)
+	}
+	return s.Seed(fixture)
+}
+
+// LoadFixtureFile seeds s with the JSON Fixture in the named file.
+func (s *Server) LoadFixtureFile(name string) error {
+	f, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	return s.LoadFixture(f)
+}
+
+// AddUser adds a user to s, replacing the profile of a known user.
+func (s *Server) AddUser(profile itembase.User) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if u, ok := s.users[profile.UUID]; ok {
+		u.profile = profile
+		return
+	}
+
+	s.users[profile.UUID] = &user{profile: profile, documents: make(map[string][]document)}
+}
+
+// AddDocuments adds documents to one of a known user's collections:
+// "transactions", "products", "buyers" or "profiles". Documents may be any
+// values that marshal to itembase entities, and replace documents with the
+// same ID.
+func (s *Server) AddDocuments(userID, collection string, documents ...interface{}) error {
+	if !isCollection(collection) {
+		return fmt.Errorf("itembasetest: unknown collection %q", collection)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	u, ok := s.users[userID]
+	if !ok {
+		return fmt.Errorf("itembasetest: unknown user %q", userID)
+	}
+
+	for _, value := range documents {
+		raw, err := json.Marshal(value)
+		if err != nil {
+			return err
+		}
+
+		var fields struct {
+			ID        string     `json:"id"`
+			CreatedAt *time.Time `json:"created_at"`
+			UpdatedAt *time.Time `json:"updated_at"`
+		}
+		if err := json.Unmarshal(raw, &fields); err != nil {
+			return fmt.Errorf("itembasetest: %s document: %w", collection, err)
+		}
+
+		doc := document{id: fields.ID, createdAt: fields.CreatedAt, updatedAt: fields.UpdatedAt, raw: raw}
+		u.documents[collection] = upsert(u.documents[collection], doc)
+	}
+
+	return nil
+}
+
+func upsert(documents []document, doc document) []document {
+	for i := range documents {
+		if documents[i].id == doc.id {
+			documents[i] = doc
+			return documents
+		}
+	}
+	return append(documents, doc)
+}
+
+// Token issues a new token for a known user, as if they had granted the
+// application access. A token issued with scopes only gives access to the
+// collections they and the scopes granted before cover, e.g.
+// connection.product for products; one issued without gives access to
+// everything.
+func (s *Server) Token(userID string, scopes ...string) (*oauth2.Token, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	u, ok := s.users[userID]
+	if !ok {
+		return nil, fmt.Errorf("itembasetest: unknown user %q", userID)
+	}
+
+	return s.issueToken(userID, u.grant(strings.Join(scopes, " "))), nil
+}
+
+// Authorize returns an authorization code for a known user, as the
+// authorization endpoint would after the user granted access.
+func (s *Server) Authorize(userID string) (string, error) {
+	return s.authorizeCode(userID, "", "")
+}
+
+// authorizeCode issues an authorization code for userID, bound to an S256
+// PKCE challenge unless it is empty. A non-empty scope is added to the scopes
+// granted by the user.
+func (s *Server) authorizeCode(userID, challenge, scope string) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	u, ok := s.users[userID]
+	if !ok {
+		return "", fmt.Errorf("itembasetest: unknown user %q", userID)
+	}
+
+	code := randomString()
+	s.codes[code] = authorization{userID: userID, challenge: challenge, scope: u.grant(scope)}
+	return code, nil
+}
+
+// grant adds the space separated scopes to those granted by u and returns all
+// of them. Scopes granted earlier are kept, as with incremental
+// authorization. An empty scope is returned as it is.
+func (u *user) grant(scope string) string {
+	if scope == "" {
+		return ""
+	}
+
+	if u.granted == nil {
+		u.granted = make(map[string]bool)
+	}
+	for _, granted := range strings.Fields(scope) {
+		u.granted[granted] = true
+	}
+
+	all := make([]string, 0, len(u.granted))
+	for granted := range u.granted {
+		all = append(all, granted)
+	}
+	sort.Strings(all)
+	return strings.Join(all, " ")
+}
+
+// Activations returns how many times the activation endpoint was called.
+func (s *Server) Activations() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.activations
+}
+
+// issueToken must be called with s.mu held. An empty scope grants access to
+// all collections.
+func (s *Server) issueToken(userID, scope string) *oauth2.Token {
+	token := &oauth2.Token{
+		AccessToken:  randomString(),
+		TokenType:    "Bearer",
+		RefreshToken: randomString(),
+		Expiry:       time.Now().Add(s.TokenLifetime),
+	}
+
+	s.accessTokens[token.AccessToken] = userID
+	s.refreshTokens[token.RefreshToken] = userID
+
+	if scope != "" {
+		s.scopes[token.AccessToken] = scope
+		s.scopes[token.RefreshToken] = scope
+		return token.WithExtra(map[string]interface{}{"scope": scope})
+	}
+	return token
+}
+
+// handleAuth implements the authorization endpoint. As there is no login, the
+// user granting access is taken from the "user" query parameter, or is the
+// only known user.
+func (s *Server) handleAuth(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+
+	if query.Get("client_id") != s.ClientID {
+		writeError(w, http.StatusUnauthorized, "unknown client_id")
+		return
+	}
+
+	userID := query.Get("user")
+	if userID == "" {
+		s.mu.Lock()
+		for id := range s.users {
+			if userID != "" {
+				userID = ""
+				break
+			}
+			userID = id
+		}
+		s.mu.Unlock()
+	}
+
+	challenge := query.Get("code_challenge")
+	switch {
+	case challenge == "" && s.RequirePKCE:
+		writeError(w, http.StatusBadRequest, "code_challenge required")
+		return
+	case challenge != "" && query.Get("code_challenge_method") != "S256":
+		writeError(w, http.StatusBadRequest, "unsupported code_challenge_method")
+		return
+	}
+
+	code, err := s.authorizeCode(userID, challenge, query.Get("scope"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	redirect, err := url.Parse(query.Get("redirect_uri"))
+	if err != nil || query.Get("redirect_uri") == "" {
+		writeError(w, http.StatusBadRequest, "invalid redirect_uri")
+		return
+	}
+
+	params := redirect.Query()
+	params.Set("code", code)
+	params.Set("state", query.Get("state"))
+	redirect.RawQuery = params.Encode()
+
+	http.Redirect(w, r, redirect.String(), http.StatusFound)
+}
+
+// handleToken implements the token endpoint for the authorization_code,
+// refresh_token and client_credentials grants.
+func (s *Server) handleToken(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	if err := r.ParseForm(); err != nil {
+		writeTokenError(w, http.StatusBadRequest, "invalid_request")
+		return
+	}
+
+	clientID, clientSecret, ok := r.BasicAuth()
+	if !ok {
+		clientID, clientSecret = r.PostForm.Get("client_id"), r.PostForm.Get("client_secret")
+	}
+	// public clients authenticate with a PKCE verifier instead of the secret
+	public := clientSecret == ""
+	if clientID != s.ClientID || (!public && clientSecret != s.ClientSecret) {
+		writeTokenError(w, http.StatusUnauthorized, "invalid_client")
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var userID, scope string
+
+	switch r.PostForm.Get("grant_type") {
+	case "client_credentials":
+		if public {
+			writeTokenError(w, http.StatusUnauthorized, "invalid_client")
+			return
+		}
+
+		// application tokens belong to no user and come without a
+		// refresh token
+		accessToken := randomString()
+		s.appTokens[accessToken] = true
+
+		response := map[string]interface{}{
+			"access_token": accessToken,
+			"token_type":   "Bearer",
+			"expires_in":   int(s.TokenLifetime / time.Second),
+		}
+		if scope := r.PostForm.Get("scope"); scope != "" {
+			response["scope"] = scope
+		}
+		writeJSON(w, http.StatusOK, response)
+		return
+	case "authorization_code":
+		code := r.PostForm.Get("code")
+		auth, ok := s.codes[code]
+		if !ok {
+			writeTokenError(w, http.StatusBadRequest, "invalid_grant")
+			return
+		}
+		delete(s.codes, code)
+
+		if auth.challenge == "" && public {
+			writeTokenError(w, http.StatusUnauthorized, "invalid_client")
+			return
+		}
+		if auth.challenge != "" && auth.challenge != challengeS256(r.PostForm.Get("code_verifier")) {
+			writeTokenError(w, http.StatusBadRequest, "invalid_grant")
+			return
+		}
+		userID, scope = auth.userID, auth.scope
+	case "refresh_token":
+		refreshToken := r.PostForm.Get("refresh_token")
+		if userID, ok = s.refreshTokens[refreshToken]; !ok {
+			writeTokenError(w, http.StatusBadRequest, "invalid_grant")
+			return
+		}
+		if public && !s.publicTokens[refreshToken] {
+			writeTokenError(w, http.StatusUnauthorized, "invalid_client")
+			return
+		}
+		scope = s.scopes[refreshToken]
+		delete(s.refreshTokens, refreshToken)
+		delete(s.publicTokens, refreshToken)
+		delete(s.scopes, refreshToken)
+	default:
+		writeTokenError(w, http.StatusBadRequest, "unsupported_grant_type")
+		return
+	}
+
+	token := s.issueToken(userID, scope)
+	if public {
+		s.publicTokens[token.RefreshToken] = true
+	}
+
+	response := map[string]interface{}{
+		"access_token":  token.AccessToken,
+		"token_type":    token.TokenType,
+		"refresh_token": token.RefreshToken,
+		"expires_in":    int(s.TokenLifetime / time.Second),
+	}
+	if scope != "" {
+		response["scope"] = scope
+	}
+	writeJSON(w, http.StatusOK, response)
+}
+
+// handleRevoke implements the token revocation endpoint of RFC 7009. Revoking
+// a refresh token also revokes the access tokens of its user.
+func (s *Server) handleRevoke(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	if err := r.ParseForm(); err != nil {
+		writeTokenError(w, http.StatusBadRequest, "invalid_request")
+		return
+	}
+
+	clientID, clientSecret, ok := r.BasicAuth()
+	if !ok {
+		clientID, clientSecret = r.PostForm.Get("client_id"), r.PostForm.Get("client_secret")
+	}
+	if clientID != s.ClientID || (clientSecret != s.ClientSecret && clientSecret != "") {
+		writeTokenError(w, http.StatusUnauthorized, "invalid_client")
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	token := r.PostForm.Get("token")
+	delete(s.accessTokens, token)
+	delete(s.appTokens, token)
+	delete(s.scopes, token)
+	if userID, ok := s.refreshTokens[token]; ok {
+		delete(s.refreshTokens, token)
+		delete(s.publicTokens, token)
+		for accessToken, owner := range s.accessTokens {
+			if owner == userID {
+				delete(s.accessTokens, accessToken)
+				delete(s.scopes, accessToken)
+			}
+		}
+	}
+
+	// unknown tokens are not an error, see RFC 7009 section 2.2
+	w.WriteHeader(http.StatusOK)
+}
+
+func (s *Server) handleMe(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	u, ok := s.authorize(w, r)
+	if !ok {
+		return
+	}
+
+	writeJSON(w, http.StatusOK, u.profile)
+}
+
+// handleUsers serves /v1/users/{id}/{collection} and
+// /v1/users/{id}/{collection}/{document id}.
+func (s *Server) handleUsers(w http.ResponseWriter, r *http.Request) {
+	parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/v1/users/"), "/")
+	if len(parts) < 2 || len(parts) > 3 || !isCollection(parts[1]) {
+		writeError(w, http.StatusNotFound, "not found")
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	u, ok := s.authorize(w, r)
+	if !ok {
+		return
+	}
+	if u.profile.UUID != parts[0] {
+		writeError(w, http.StatusForbidden, "token does not grant access to user "+parts[0])
+		return
+	}
+
+	required := "connection." + strings.TrimSuffix(parts[1], "s")
+	if scope, ok := s.scopes[accessToken(r)]; ok && !hasScope(scope, required) {
+		writeError(w, http.StatusForbidden, "token lacks scope "+required)
+		return
+	}
+
+	documents := u.documents[parts[1]]
+
+	if len(parts) == 3 {
+		for _, doc := range documents {
+			if doc.id == parts[2] {
+				writeJSON(w, http.StatusOK, doc.raw)
+				return
+			}
+		}
+		writeError(w, http.StatusNotFound, "document not found")
+		return
+	}
+
+	page, err := s.page(documents, r.URL.Query())
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	writeJSON(w, http.StatusOK, page)
+}
+
+func (s *Server) handleActivate(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// activation is an application level call, which also accepts tokens
+	// of the client credentials grant
+	if !s.appTokens[accessToken(r)] {
+		if _, ok := s.authorize(w, r); !ok {
+			return
+		}
+	}
+
+	s.activations++
+	writeJSON(w, http.StatusOK, map[string]bool{"activated": true})
+}
+
+// authorize returns the user owning the request's bearer token. It must be
+// called with s.mu held.
+func (s *Server) authorize(w http.ResponseWriter, r *http.Request) (*user, bool) {
+	userID, ok := s.accessTokens[accessToken(r)]
+	if !ok {
+		writeError(w, http.StatusUnauthorized, "invalid access token")
+		return nil, false
+	}
+
+	return s.users[userID], true
+}
+
+func accessToken(r *http.Request) string {
+	return strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+}
+
+func hasScope(scope, required string) bool {
+	for _, granted := range strings.Fields(scope) {
+		if granted == required {
+			return true
+		}
+	}
+	return false
+}
+
+// page applies the date filters and pagination parameters of query to
+// documents, ordered by creation date.
+func (s *Server) page(documents []document, query url.Values) (interface{}, error) {
+	var filters []func(document) bool
+
+	for _, filter := range []struct {
+		param  string
+		date   func(document) *time.Time
+		before bool
+	}{
+		{"created_at_from", func(d document) *time.Time { return d.createdAt }, false},
+		{"created_at_to", func(d document) *time.Time { return d.createdAt }, true},
+		{"updated_at_from", func(d document) *time.Time { return d.updatedAt }, false},
+		{"updated_at_to", func(d document) *time.Time { return d.updatedAt }, true},
+	} {
+		value := query.Get(filter.param)
+		if value == "" {
+			continue
+		}
+
+		bound, err := time.Parse(time.RFC3339Nano, value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s: %v", filter.param, err)
+		}
+
+		filter := filter
+		filters = append(filters, func(d document) bool {
+			date := filter.date(d)
+			if date == nil {
+				return false
+			}
+			if filter.before {
+				return !date.After(bound)
+			}
+			return !date.Before(bound)
+		})
+	}
+
+	var matching []document
+	for _, doc := range documents {
+		keep := true
+		for _, filter := range filters {
+			keep = keep && filter(doc)
+		}
+		if keep {
+			matching = append(matching, doc)
+		}
+	}
+
+	sort.SliceStable(matching, func(i, j int) bool {
+		a, b := matching[i].createdAt, matching[j].createdAt
+		return a != nil && (b == nil || a.Before(*b))
+	})
+
+	start, err := intParam(query, "start_at_document", 0)
+	if err != nil {
+		return nil, err
+	}
+	limit, err := intParam(query, "document_limit", s.PageSize)
+	if err != nil {
+		return nil, err
+	}
+
+	end := start + limit
+	if start > len(matching) {
+		start = len(matching)
+	}
+	if end > len(matching) {
+		end = len(matching)
+	}
+
+	returned := make([]json.RawMessage, 0, end-start)
+	for _, doc := range matching[start:end] {
+		returned = append(returned, doc.raw)
+	}
+
+	return map[string]interface{}{
+		"documents":              returned,
+		"num_documents_found":    len(matching),
+		"num_documents_returned": len(returned),
+	}, nil
+}
+
+func intParam(query url.Values, name string, fallback int) (int, error) {
+	value := query.Get(name)
+	if value == "" {
+		return fallback, nil
+	}
+
+	n, err := strconv.Atoi(value)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid %s %q", name, value)
+	}
+	return n, nil
+}
+
+func isCollection(name string) bool {
+	for _, collection := range collections {
+		if name == collection {
+			return true
+		}
+	}
+	return false
+}
+
+func writeJSON(w http.ResponseWriter, status int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(body)
+}
+
+// writeError writes an error in the format of itembase.Error.
+func writeError(w http.ResponseWriter, status int, message string) {
+	writeJSON(w, status, map[string]interface{}{"message": message, "code": status})
+}
+
+// writeTokenError writes an OAuth2 token endpoint error.
+func writeTokenError(w http.ResponseWriter, status int, code string) {
+	writeJSON(w, status, map[string]string{"error": code})
+}
+
+// challengeS256 returns the S256 PKCE challenge for verifier.
+func challengeS256(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+func randomString() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return fmt.Sprintf("%x", b)
+}
@@ -0,0 +1,155 @@
+package itembase
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+)
+
+// documentPage is a single page of an itembase collection response. Documents
+// are kept as raw JSON so they are only decoded once, into their final type.
+type documentPage struct {
+	Documents            []json.RawMessage `json:"documents"`
+	NumDocumentsFound    int               `json:"num_documents_found"`
+	NumDocumentsReturned int               `json:"num_documents_returned"`
+}
+
+// An Iterator streams the documents of a paginated itembase collection. Pages
+// are requested lazily using start_at_document, so only one page is held in
+// memory at a time.
+//
+// The page size is the one set with Limit, or the itembase default when no
+// limit was given. Offset sets the first document, and Max caps the total
+// number of documents the Iterator returns.
+//
+//	it := storeRef.Transactions().Limit(100).Iterate()
+//	for it.Next() {
+//		var transaction itembase.Transaction
+//		if err := it.Decode(&transaction); err != nil {
+//			return err
+//		}
+//		// ...
+//	}
+//	if err := it.Err(); err != nil {
+//		return err
+//	}
+type Iterator struct {
+	ctx    context.Context
+	client *client
+
+	// offset is the start_at_document of the next page to fetch
+	offset int
+	// returned counts the documents handed out by Next
+	returned int
+	found    int
+
+	page     []json.RawMessage
+	position int
+	document json.RawMessage
+
+	// last is set once the final page has been fetched
+	last bool
+	err  error
+}
+
+func (c *client) Iterate() *Iterator {
+	return c.IterateContext(context.Background())
+}
+
+func (c *client) IterateContext(ctx context.Context) *Iterator {
+	it := &Iterator{ctx: ctx, client: c}
+
+	if offset, ok := c.params["start_at_document"]; ok {
+		if err := json.Unmarshal([]byte(offset), &it.offset); err != nil {
+			it.err = fmt.Errorf("invalid start_at_document %q: %v", offset, err)
+		}
+	}
+
+	return it
+}
+
+// Next advances the Iterator to the next document, fetching a new page when
+// the current one is exhausted. It returns false when there are no more
+// documents or an error occurred; call Err to tell the two apart.
+func (it *Iterator) Next() bool {
+	it.document = nil
+
+	if it.err != nil {
+		return false
+	}
+
+	if it.client.max > 0 && it.returned >= it.client.max {
+		return false
+	}
+
+	for it.position >= len(it.page) {
+		if it.last {
+			return false
+		}
+		if !it.fetch() {
+			return false
+		}
+	}
+
+	it.document = it.page[it.position]
+	it.position++
+	it.returned++
+
+	return true
+}
+
+// fetch requests the page starting at the current offset.
+func (it *Iterator) fetch() bool {
+	if err := it.ctx.Err(); err != nil {
+		it.err = err
+		return false
+	}
+
+	var page documentPage
+
+	d := it.client.clientWithNewParam("start_at_document", it.offset)
+	if err := d.call(it.ctx, "GET", d.url, &page); err != nil {
+		it.err = fmt.Errorf("fetching documents from %d: %w", it.offset, err)
+		return false
+	}
+
+	it.client.options.logger().Debug("fetched page", "user", it.client.user, "endpoint", it.client.url,
+		"page", it.offset, "found", page.NumDocumentsFound, "returned", page.NumDocumentsReturned)
+
+	it.page = page.Documents
+	it.position = 0
+	it.found = page.NumDocumentsFound
+	it.offset += len(page.Documents)
+
+	if len(page.Documents) == 0 || it.offset >= page.NumDocumentsFound {
+		it.last = true
+	}
+
+	return true
+}
+
+// Document returns the raw JSON of the current document. It is only valid
+// after a call to Next returned true.
+func (it *Iterator) Document() json.RawMessage {
+	return it.document
+}
+
+// Decode unmarshals the current document into dest, which would typically be
+// a *Transaction, *Product, *Buyer or *Profile.
+func (it *Iterator) Decode(dest interface{}) error {
+	if it.document == nil {
+		return fmt.Errorf("no current document")
+	}
+	return json.Unmarshal(it.document, dest)
+}
+
+// Found returns the total number of documents itembase reported for the query,
+// as of the most recently fetched page.
+func (it *Iterator) Found() int {
+	return it.found
+}
+
+// Err returns the error, if any, that stopped the iteration.
+func (it *Iterator) Err() error {
+	return it.err
+}
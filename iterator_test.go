@@ -0,0 +1,170 @@
+package itembase_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"gopkg.in/saasbuilders/itembase.v0"
+	"gopkg.in/saasbuilders/itembase.v0/itembasetest"
+)
+
+var iteratorEpoch = time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// newPagedUser returns a client for a user with 25 transactions t00 to t24,
+// created a day apart, served in pages of 10.
+func newPagedUser(t *testing.T, middleware ...itembase.Middleware) itembase.Client {
+	t.Helper()
+
+	server := itembasetest.NewServer()
+	t.Cleanup(server.Close)
+	server.PageSize = 10
+
+	fixture := itembasetest.Fixture{User: itembase.User{UUID: testUserID}}
+	for i := 0; i < 25; i++ {
+		createdAt := iteratorEpoch.AddDate(0, 0, i)
+		fixture.Transactions = append(fixture.Transactions, itembase.Transaction{
+			ID:        itembase.TransactionID(fmt.Sprintf("t%02d", i)),
+			CreatedAt: &createdAt,
+		})
+	}
+	if err := server.Seed(fixture); err != nil {
+		t.Fatal(err)
+	}
+
+	config := server.Config()
+	config.Middleware = middleware
+	storeRef, err := itembase.New(config, nil).ForUser(context.Background(), testUserID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return storeRef
+}
+
+// iterate returns the IDs of the documents it hands out, and its error.
+func iterate(t *testing.T, it *itembase.Iterator) (string, error) {
+	t.Helper()
+
+	var transactions []itembase.Transaction
+	for it.Next() {
+		var transaction itembase.Transaction
+		if err := it.Decode(&transaction); err != nil {
+			t.Fatal(err)
+		}
+		transactions = append(transactions, transaction)
+	}
+	return ids(transactions), it.Err()
+}
+
+// idRange returns the IDs from t<from> up to, but not including, t<to>.
+func idRange(from, to int) (s string) {
+	for i := from; i < to; i++ {
+		if i > from {
+			s += " "
+		}
+		s += fmt.Sprintf("t%02d", i)
+	}
+	return
+}
+
+func TestIterator(t *testing.T) {
+	storeRef := newPagedUser(t)
+
+	for _, test := range []struct {
+		name  string
+		query itembase.Client
+		want  string
+		found int
+	}{
+		{"all", storeRef.Transactions(), idRange(0, 25), 25},
+		{"limit", storeRef.Transactions().Limit(7), idRange(0, 25), 25},
+		{"offset", storeRef.Transactions().Offset(5), idRange(5, 25), 25},
+		{"max", storeRef.Transactions().Offset(5).Max(12), idRange(5, 17), 25},
+		{"created from", storeRef.Transactions().CreatedAtFrom(iteratorEpoch.AddDate(0, 0, 10)), idRange(10, 25), 15},
+		{"created to", storeRef.Transactions().CreatedAtTo(iteratorEpoch.AddDate(0, 0, 3)), idRange(0, 4), 4},
+		{"empty", storeRef.Transactions().CreatedAtFrom(iteratorEpoch.AddDate(1, 0, 0)), "", 0},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			it := test.query.Iterate()
+			got, err := iterate(t, it)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != test.want {
+				t.Errorf("documents = %q, want %q", got, test.want)
+			}
+			if it.Found() != test.found {
+				t.Errorf("Found() = %d, want %d", it.Found(), test.found)
+			}
+		})
+	}
+}
+
+func TestGetAllInto(t *testing.T) {
+	storeRef := newPagedUser(t)
+
+	var transactions itembase.Transactions
+	if err := storeRef.Transactions().Max(21).GetAllInto(&transactions); err != nil {
+		t.Fatal(err)
+	}
+	if got := ids(transactions.Transactions); got != idRange(0, 21) {
+		t.Errorf("documents = %q", got)
+	}
+	if want := iteratorEpoch.AddDate(0, 0, 20); !transactions.MaxCreatedAt().Equal(want) {
+		t.Errorf("MaxCreatedAt() = %v, want %v", transactions.MaxCreatedAt(), want)
+	}
+}
+
+func TestIteratorCancel(t *testing.T) {
+	storeRef := newPagedUser(t)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	it := storeRef.Transactions().IterateContext(ctx)
+	if !it.Next() {
+		t.Fatal(it.Err())
+	}
+	cancel()
+
+	// the page already fetched is handed out, the next one is not fetched
+	got, err := iterate(t, it)
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("Err() = %v, want context.Canceled", err)
+	}
+	if got != idRange(1, 10) {
+		t.Errorf("documents = %q, want %q", got, idRange(1, 10))
+	}
+}
+
+func TestIteratorPageError(t *testing.T) {
+	// the second page fails
+	failing := func(next itembase.API) itembase.API {
+		return itembase.APIFunc(func(ctx context.Context, method, path, auth string, body interface{}, params map[string]string, dest interface{}) error {
+			if params["start_at_document"] == "10" {
+				return &itembase.APIError{Message: "Service Unavailable", StatusCode: http.StatusServiceUnavailable, Method: method, Endpoint: path}
+			}
+			return itembase.CallAPI(ctx, next, method, path, auth, body, params, dest)
+		})
+	}
+	storeRef := newPagedUser(t, failing)
+
+	it := storeRef.Transactions().Iterate()
+	got, err := iterate(t, it)
+	if got != idRange(0, 10) {
+		t.Errorf("documents = %q, want %q", got, idRange(0, 10))
+	}
+	var apiErr *itembase.APIError
+	if !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusServiceUnavailable {
+		t.Fatalf("Err() = %v, want a 503 *APIError", err)
+	}
+	if it.Next() {
+		t.Error("Next() = true after an error")
+	}
+
+	var transactions itembase.Transactions
+	if err := storeRef.Transactions().GetAllInto(&transactions); !errors.As(err, &apiErr) {
+		t.Errorf("GetAllInto: err = %v, want the page's *APIError", err)
+	}
+}
@@ -0,0 +1,43 @@
+package itembase
+
+import (
+	"log/slog"
+)
+
+// A Logger receives leveled, structured log records from a Client. The
+// arguments following msg are alternating keys and values, e.g.
+//
+//	logger.Debug("fetched page", "user", userID, "page", 2)
+//
+// Both *slog.Logger and log15.Logger satisfy this interface.
+type Logger interface {
+	Debug(msg string, keyvals ...interface{})
+	Info(msg string, keyvals ...interface{})
+	Warn(msg string, keyvals ...interface{})
+	Error(msg string, keyvals ...interface{})
+}
+
+// SlogLogger returns a Logger writing to logger, or to slog.Default() if
+// logger is nil. Records carry a "component" attribute set to "itembase".
+func SlogLogger(logger *slog.Logger) Logger {
+	if logger == nil {
+		logger = slog.Default()
+	}
+	return logger.With("component", "itembase")
+}
+
+// nopLogger discards all log records. It is used when Config.Logger is nil.
+type nopLogger struct{}
+
+func (nopLogger) Debug(msg string, keyvals ...interface{}) {}
+func (nopLogger) Info(msg string, keyvals ...interface{})  {}
+func (nopLogger) Warn(msg string, keyvals ...interface{})  {}
+func (nopLogger) Error(msg string, keyvals ...interface{}) {}
+
+// logger returns the configured Logger, or one discarding everything.
+func (options Config) logger() Logger {
+	if options.Logger == nil {
+		return nopLogger{}
+	}
+	return options.Logger
+}
@@ -0,0 +1,54 @@
+package itembase
+
+import (
+	"context"
+)
+
+// A Middleware wraps an API to add behaviour around its calls, such as
+// logging, metrics, caching, header injection or fault injection. Middleware
+// is registered through Config.Middleware.
+//
+//	func timing(next itembase.API) itembase.API {
+//		return itembase.APIFunc(func(ctx context.Context, method, path, auth string, body interface{}, params map[string]string, dest interface{}) error {
+//			start := time.Now()
+//			err := itembase.CallAPI(ctx, next, method, path, auth, body, params, dest)
+//			observe(path, time.Since(start), err)
+//			return err
+//		})
+//	}
+type Middleware func(next API) API
+
+// APIFunc adapts an ordinary function to both API and ContextAPI. Call invokes
+// the function with context.Background().
+type APIFunc func(ctx context.Context, method, path, auth string, body interface{}, params map[string]string, dest interface{}) error
+
+func (f APIFunc) Call(method, path, auth string, body interface{}, params map[string]string, dest interface{}) error {
+	return f(context.Background(), method, path, auth, body, params, dest)
+}
+
+func (f APIFunc) CallContext(ctx context.Context, method, path, auth string, body interface{}, params map[string]string, dest interface{}) error {
+	return f(ctx, method, path, auth, body, params, dest)
+}
+
+// CallAPI makes a call through api, using CallContext if api implements
+// ContextAPI. Otherwise ctx is only checked before the call. Middleware should
+// use CallAPI to invoke the next API, so the context is passed on.
+func CallAPI(ctx context.Context, api API, method, path, auth string, body interface{}, params map[string]string, dest interface{}) error {
+	if contextAPI, ok := api.(ContextAPI); ok {
+		return contextAPI.CallContext(ctx, method, path, auth, body, params, dest)
+	}
+
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	return api.Call(method, path, auth, body, params, dest)
+}
+
+// chain wraps api in middleware, the first of which is the outermost.
+func chain(api API, middleware []Middleware) API {
+	for i := len(middleware) - 1; i >= 0; i-- {
+		api = middleware[i](api)
+	}
+	return api
+}
@@ -1,48 +1,80 @@
 package itembase
 
 import (
+	"context"
 	"crypto/rand"
 	"errors"
 	"fmt"
-	"log"
 	"net/http"
 
-	"golang.org/x/net/context"
 	"golang.org/x/oauth2"
 )
 
-func (c *client) newConf() *oauth2.Config {
-	var endpointURL string
+var (
+	// ErrNoTokenStore is returned when a token must be saved but the Config
+	// has no TokenSaver.
+	ErrNoTokenStore = errors.New("No Token Store!")
+
+	// ErrNoTokenCache is returned when a token must be loaded but the Config
+	// has no TokenLoader.
+	ErrNoTokenCache = errors.New("No Token Cache!")
+
+	// ErrPermissionRequired is returned when a user has to grant the
+	// application access, but no authorization code could be obtained for
+	// them.
+	ErrPermissionRequired = errors.New("itembase: user permission required")
+
+	// ErrTokenExchange is returned when an authorization code or refresh
+	// token could not be exchanged for an access token.
+	ErrTokenExchange = errors.New("itembase: token exchange failed")
 
+	// ErrTokenSave is returned when a refreshed token could not be passed to
+	// the TokenSaver.
+	ErrTokenSave = errors.New("itembase: saving token failed")
+)
+
+// setEndpoints resolves the itembase URLs for the client's environment,
+// applying the overrides from Config.Endpoints. It must only be called on a
+// client that has not been shared yet.
+func (c *client) setEndpoints() {
+	defaults := SandboxEndpoints
 	if c.production {
-		endpointURL = "https://accounts.itembase.com/oauth/v2"
-		c.me = "https://users.itembase.com/v1/me"
-		c.root = "https://api.itembase.io/v1"
-		c.activation = "https://solutionservice.itembase.com"
-	} else {
-		endpointURL = "http://sandbox.accounts.itembase.io/oauth/v2"
-		c.me = "http://sandbox.users.itembase.io/v1/me"
-		c.root = "http://sandbox.api.itembase.io/v1"
-		c.activation = "http://sandbox.solutionservice.itembase.io"
+		defaults = ProductionEndpoints
 	}
 
+	c.endpoints = c.options.Endpoints.withDefaults(defaults)
+	c.me = c.endpoints.MeURL
+	c.root = c.endpoints.APIRoot
+	c.activation = c.endpoints.ActivationURL
+}
+
+func (c *client) newConf() *oauth2.Config {
 	return &oauth2.Config{
 		ClientID:     c.options.ClientID,
 		ClientSecret: c.options.ClientSecret,
 		Scopes:       c.options.Scopes,
 		RedirectURL:  c.options.RedirectURL,
 		Endpoint: oauth2.Endpoint{
-			AuthURL:  endpointURL + "/auth",
-			TokenURL: endpointURL + "/token",
+			AuthURL:  c.endpoints.AuthURL,
+			TokenURL: c.endpoints.TokenURL,
 		},
 	}
 }
 
+// oauthContext returns ctx carrying Config.HTTPClient, if set, for use by the
+// oauth2 package in token exchanges and authorized clients.
+func (c *client) oauthContext(ctx context.Context) context.Context {
+	if c.options.HTTPClient == nil {
+		return ctx
+	}
+	return context.WithValue(ctx, oauth2.HTTPClient, c.options.HTTPClient)
+}
+
 func (c *client) SaveToken(userID string, token *oauth2.Token) (err error) {
 	if c.options.TokenHandler.TokenSaver != nil {
 		err = c.options.TokenHandler.TokenSaver(userID, token)
 	} else {
-		err = errors.New("No Token Store!")
+		err = ErrNoTokenStore
 	}
 	return
 }
@@ -51,7 +83,7 @@ func (c *client) GetCachedToken(userID string) (token *oauth2.Token, err error)
 	if c.options.TokenHandler.TokenLoader != nil {
 		token, err = c.options.TokenHandler.TokenLoader(userID)
 	} else {
-		err = errors.New("No Token Cache!")
+		err = ErrNoTokenCache
 	}
 	return
 }
@@ -62,13 +94,13 @@ func (c *client) GiveTokenPermissions(authURL string) (authcode string, err erro
 
 	if c.options.TokenHandler.TokenPermissions != nil {
 		if authcode, err = c.options.TokenHandler.TokenPermissions(authURL); err != nil {
-			log.Fatal(err)
+			return "", fmt.Errorf("%w: %w", ErrPermissionRequired, err)
 		}
 	} else {
-		fmt.Println("Please go to the following URL : ", authURL)
-		if _, err := fmt.Scan(&authcode); err != nil {
-			log.Fatal(err)
-		}
+		// the URL carries the state and PKCE challenge, so it is neither
+		// printed nor logged
+		c.options.logger().Warn("user authorization required, but no TokenPermissions handler is set")
+		return "", fmt.Errorf("%w: no TokenPermissions handler", ErrPermissionRequired)
 	}
 
 	// Use the authorization code that is pushed to the redirect URL.
@@ -78,22 +110,36 @@ func (c *client) GiveTokenPermissions(authURL string) (authcode string, err erro
 	return
 }
 
-// UserOAuthClient returns an oauth2 client for a specific user
+// UserOAuthClient returns an oauth2 client for a specific user. Tokens
+// refreshed by the client are passed to the TokenSaver.
 func (c *client) UserOAuthClient(ctx context.Context, config *oauth2.Config, userID string) (client *http.Client, err error) {
-	var userToken *oauth2.Token
+	source, err := c.userTokenSource(ctx, config, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	return oauth2.NewClient(c.oauthContext(ctx), source), nil
+}
 
-	if userToken, err = c.GetCachedToken(userID); err != nil {
+// userTokenSource returns a TokenSource for userID, starting from the cached
+// token or, if there is none, a newly authorized one. Tokens differing from
+// the last one are passed to the TokenSaver.
+func (c *client) userTokenSource(ctx context.Context, config *oauth2.Config, userID string) (oauth2.TokenSource, error) {
+	userToken, err := c.GetCachedToken(userID)
+	if err != nil || userToken == nil {
 		// if token for user is not cached then go through oauth2 flow
 		if userToken, err = c.newUserToken(ctx, config, userID); err != nil {
-			return
+			return nil, err
 		}
 	}
 
-	if !userToken.Valid() { // if user token is expired
-		userToken = &oauth2.Token{RefreshToken: userToken.RefreshToken}
+	start := userToken
+	if !start.Valid() { // if user token is expired
+		start = &oauth2.Token{RefreshToken: userToken.RefreshToken}
 	}
 
-	return config.Client(ctx, userToken), err
+	source := config.TokenSource(c.oauthContext(ctx), start)
+	return NewSavingTokenSource(source, userID, userToken, c.options.TokenHandler.TokenSaver), nil
 }
 
 // TokenOAuthClient returns an oauth2 client for a specific token
@@ -103,45 +149,73 @@ func (c *client) TokenOAuthClient(ctx context.Context, config *oauth2.Config, us
 		userToken = &oauth2.Token{RefreshToken: userToken.RefreshToken}
 	}
 
-	return config.Client(ctx, userToken), err
+	return config.Client(c.oauthContext(ctx), userToken), err
 }
 
 func (c *client) newUserToken(ctx context.Context, config *oauth2.Config, userID string) (*oauth2.Token, error) {
-	stateBytes := make([]byte, 32)
-	_, err := rand.Read(stateBytes)
+	state, err := newState()
+	if err != nil {
+		return nil, err
+	}
+	opts, verifier, err := c.authCodeOptions()
 	if err != nil {
-		log.Fatalf("Unable to read random bytes: %v", err)
 		return nil, err
 	}
-	state := fmt.Sprintf("%x", stateBytes)
-	authURL := config.AuthCodeURL(state, oauth2.AccessTypeOffline)
+	authURL := config.AuthCodeURL(state, opts...)
 
 	authcode, err := c.GiveTokenPermissions(authURL)
 	if err != nil {
-		log.Fatalf("Error when getting token permissions: %v", err)
 		return nil, err
 	}
 
-	token, err := c.HandleOAuthCode(authcode)
+	var exchangeOpts []oauth2.AuthCodeOption
+	if verifier != "" {
+		exchangeOpts = append(exchangeOpts, PKCEVerifier(verifier))
+	}
+	token, err := c.HandleOAuthCodeContext(ctx, authcode, exchangeOpts...)
 	if err != nil {
-		log.Fatalf("Error when handling OAuth Code error: %v", err)
 		return nil, err
 	}
 
-	c.SaveToken(userID, token) // save token to datastore
+	// save token to datastore, or the user would have to consent again
+	if err := c.SaveToken(userID, token); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrTokenSave, err)
+	}
 
 	return token, nil
 }
 
-// HandleOAuthCode returns a valid token for an OAuth code
-func (c *client) HandleOAuthCode(authcode string) (*oauth2.Token, error) {
+// newState returns a random value for the state parameter of an
+// authorization request.
+func newState() (string, error) {
+	stateBytes := make([]byte, 32)
+	if _, err := rand.Read(stateBytes); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%x", stateBytes), nil
+}
+
+// AuthCodeURL returns the URL of the itembase consent page, which redirects
+// to Config.RedirectURL with an authorization code and state.
+func (c *client) AuthCodeURL(state string, opts ...oauth2.AuthCodeOption) string {
+	return c.newConf().AuthCodeURL(state, opts...)
+}
+
+// HandleOAuthCode returns a valid token for an OAuth code. Pass PKCEVerifier
+// when the authorization URL carried a PKCE challenge.
+func (c *client) HandleOAuthCode(authcode string, opts ...oauth2.AuthCodeOption) (*oauth2.Token, error) {
+	return c.HandleOAuthCodeContext(context.Background(), authcode, opts...)
+}
+
+// HandleOAuthCodeContext is like HandleOAuthCode, but performs the token
+// exchange with ctx.
+func (c *client) HandleOAuthCodeContext(ctx context.Context, authcode string, opts ...oauth2.AuthCodeOption) (*oauth2.Token, error) {
 
 	config := c.newConf()
-	token, err := config.Exchange(oauth2.NoContext, authcode)
+	token, err := config.Exchange(c.oauthContext(ctx), authcode, opts...)
 
 	if err != nil {
-		log.Fatalln("Exchange error when handling OAuthCode:", err)
-		return nil, err
+		return nil, fmt.Errorf("%w: %w", ErrTokenExchange, err)
 	}
 
 	return token, nil
@@ -151,42 +225,39 @@ func (c *client) HandleOAuthCode(authcode string) (*oauth2.Token, error) {
 // GetUserIDForToken looks up the corresponding itembase UserID based on
 // OAuth2 Token
 func (c *client) GetUserIDForToken(token *oauth2.Token) (string, error) {
+	return c.GetUserIDForTokenContext(context.Background(), token)
+}
 
-	log.Println("GetUserIDForToken", token)
+// GetUserIDForTokenContext is like GetUserIDForToken, but performs the "me"
+// lookup with ctx.
+func (c *client) GetUserIDForTokenContext(ctx context.Context, token *oauth2.Token) (string, error) {
 
 	tokenRef := NewClient(c.root, token.AccessToken, c.options, nil)
 
-	me, err := tokenRef.Me()
+	me, err := tokenRef.MeContext(ctx)
 	if err != nil {
-		log.Println("Could not run Me call:", me, err)
+		c.options.logger().Warn("looking up user for token failed", "error", err)
 		return "", err
 	}
 
 	return me.UUID, nil
 }
 
-func (c *client) getUserToken(userID string) (token *oauth2.Token) {
+func (c *client) getUserToken(ctx context.Context, userID string) (*oauth2.Token, error) {
 	config := c.newConf()
-	client, err := c.UserOAuthClient(oauth2.NoContext, config, userID)
-
-	_, err = client.Get(c.me)
-	if err == nil {
-		fmt.Errorf("Fetch should return an error if no refresh token is set")
+	source, err := c.userTokenSource(ctx, config, userID)
+	if err != nil {
+		return nil, err
 	}
 
-	token, err = client.Transport.(*oauth2.Transport).Source.Token()
-
+	token, err := source.Token()
+	if errors.Is(err, ErrTokenSave) {
+		return nil, err
+	}
 	if err != nil {
-		log.Println("Exchange error when getting Token:", err)
-		if token, err = c.newUserToken(oauth2.NoContext, config, userID); err != nil {
-			return
-		}
-		if err != nil {
-			log.Fatalln("Exchange error when getting User Token:", err)
-		}
-	} else {
-		c.SaveToken(userID, token)
+		c.options.logger().Warn("refreshing token failed, requesting permission", "user", userID, "error", err)
+		return c.newUserToken(ctx, config, userID)
 	}
 
-	return
+	return token, nil
 }
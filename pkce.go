@@ -0,0 +1,49 @@
+package itembase
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+
+	"golang.org/x/oauth2"
+)
+
+// NewPKCEVerifier returns a random PKCE code verifier (RFC 7636) to be kept
+// with the state of an authorization request.
+func NewPKCEVerifier() (string, error) {
+	verifierBytes := make([]byte, 32)
+	if _, err := rand.Read(verifierBytes); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(verifierBytes), nil
+}
+
+// PKCEChallenge returns the options adding the S256 code challenge for
+// verifier to an authorization URL built by AuthCodeURL.
+func PKCEChallenge(verifier string) []oauth2.AuthCodeOption {
+	sum := sha256.Sum256([]byte(verifier))
+	return []oauth2.AuthCodeOption{
+		oauth2.SetAuthURLParam("code_challenge", base64.RawURLEncoding.EncodeToString(sum[:])),
+		oauth2.SetAuthURLParam("code_challenge_method", "S256"),
+	}
+}
+
+// PKCEVerifier returns the option passing verifier to the token exchange of
+// HandleOAuthCode.
+func PKCEVerifier(verifier string) oauth2.AuthCodeOption {
+	return oauth2.SetAuthURLParam("code_verifier", verifier)
+}
+
+// authCodeOptions returns the options for a new authorization URL, and the
+// PKCE verifier to exchange its code with if Config.PKCE is set.
+func (c *client) authCodeOptions() (opts []oauth2.AuthCodeOption, verifier string, err error) {
+	opts = []oauth2.AuthCodeOption{oauth2.AccessTypeOffline}
+	if !c.options.PKCE {
+		return opts, "", nil
+	}
+
+	if verifier, err = NewPKCEVerifier(); err != nil {
+		return nil, "", err
+	}
+	return append(opts, PKCEChallenge(verifier)...), verifier, nil
+}
@@ -0,0 +1,80 @@
+package itembase_test
+
+import (
+	"errors"
+	"net/http"
+	"net/url"
+	"testing"
+
+	"gopkg.in/saasbuilders/itembase.v0"
+)
+
+func TestPKCEPublicClient(t *testing.T) {
+	server, h, store := newTestOAuthHandler(t, func(config *itembase.Config) {
+		config.PKCE = true
+		config.ClientSecret = ""
+	})
+	server.RequirePKCE = true
+
+	query, cookies, failed := connect(t, server, h)
+	if failed != nil {
+		t.Fatalf("authorization failed: status %d", failed.StatusCode)
+	}
+	if response := callback(h, query, cookies); response.Code != http.StatusOK {
+		t.Fatalf("Callback: status %d: %s", response.Code, response.Body)
+	}
+	if store.get(testUserID) == nil {
+		t.Error("token not saved")
+	}
+}
+
+func TestPKCERequired(t *testing.T) {
+	server, h, _ := newTestOAuthHandler(t, nil)
+	server.RequirePKCE = true
+
+	if _, _, failed := connect(t, server, h); failed == nil || failed.StatusCode != http.StatusBadRequest {
+		t.Errorf("authorization without challenge was not rejected")
+	}
+}
+
+func TestPKCEWrongVerifier(t *testing.T) {
+	server, _, _ := newTestOAuthHandler(t, nil)
+	config := server.Config()
+	config.RedirectURL = "https://app.example/oauth/callback"
+	config.ClientSecret = ""
+	client := itembase.New(config, nil)
+
+	verifier, err := itembase.NewPKCEVerifier()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	browser := server.Client()
+	browser.CheckRedirect = func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }
+	authorize := func() string {
+		response, err := browser.Get(client.AuthCodeURL("state", itembase.PKCEChallenge(verifier)...))
+		if err != nil {
+			t.Fatal(err)
+		}
+		response.Body.Close()
+		redirect, err := url.Parse(response.Header.Get("Location"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return redirect.Query().Get("code")
+	}
+
+	other, err := itembase.NewPKCEVerifier()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.HandleOAuthCode(authorize(), itembase.PKCEVerifier(other)); !errors.Is(err, itembase.ErrTokenExchange) {
+		t.Errorf("wrong verifier: err = %v, want ErrTokenExchange", err)
+	}
+	if _, err := client.HandleOAuthCode(authorize()); !errors.Is(err, itembase.ErrTokenExchange) {
+		t.Errorf("no verifier: err = %v, want ErrTokenExchange", err)
+	}
+	if _, err := client.HandleOAuthCode(authorize(), itembase.PKCEVerifier(verifier)); err != nil {
+		t.Errorf("right verifier: %v", err)
+	}
+}
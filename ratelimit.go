@@ -0,0 +1,197 @@
+package itembase
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// RateLimit configures a RateLimiter. Rates are in requests per second; a zero
+// rate leaves the corresponding bucket unlimited.
+type RateLimit struct {
+	// Global limits the requests of all users together.
+	Global      float64
+	GlobalBurst int
+
+	// PerUser limits the requests made on behalf of each single user.
+	PerUser      float64
+	PerUserBurst int
+
+	// AdaptToHeaders makes the limiter pause a user's requests when
+	// itembase reports the limit as exhausted, through X-RateLimit-Remaining
+	// and X-RateLimit-Reset, or through Retry-After on a 429 response.
+	AdaptToHeaders bool
+
+	// OnWait, if set, is called whenever a request was delayed by the
+	// limiter, with the user it was made for and the time it waited.
+	OnWait func(userID string, wait time.Duration)
+}
+
+// RateLimiterStats are counters describing the throttling done by a
+// RateLimiter.
+type RateLimiterStats struct {
+	// Requests is the number of requests that passed the limiter.
+	Requests int64
+
+	// Throttled is the number of requests that had to wait.
+	Throttled int64
+
+	// TotalWait and MaxWait are the sum and maximum of all waits.
+	TotalWait time.Duration
+	MaxWait   time.Duration
+}
+
+// A RateLimiter throttles the requests of one itembase application with token
+// buckets, globally and per user. Share a single RateLimiter between all
+// clients of an application by setting it in their Config.
+type RateLimiter struct {
+	limit  RateLimit
+	global *rate.Limiter
+
+	mu     sync.Mutex
+	users  map[string]*rate.Limiter
+	pauses map[string]time.Time
+	stats  RateLimiterStats
+}
+
+// NewRateLimiter returns a RateLimiter enforcing limit.
+func NewRateLimiter(limit RateLimit) *RateLimiter {
+	return &RateLimiter{
+		limit:  limit,
+		global: newBucket(limit.Global, limit.GlobalBurst),
+		users:  make(map[string]*rate.Limiter),
+		pauses: make(map[string]time.Time),
+	}
+}
+
+func newBucket(perSecond float64, burst int) *rate.Limiter {
+	if perSecond <= 0 {
+		return rate.NewLimiter(rate.Inf, 0)
+	}
+	if burst < 1 {
+		burst = 1
+	}
+	return rate.NewLimiter(rate.Limit(perSecond), burst)
+}
+
+// Wait blocks until a request for userID is allowed, or ctx is done.
+func (l *RateLimiter) Wait(ctx context.Context, userID string) error {
+	start := time.Now()
+
+	l.mu.Lock()
+	user, ok := l.users[userID]
+	if !ok {
+		user = newBucket(l.limit.PerUser, l.limit.PerUserBurst)
+		l.users[userID] = user
+	}
+	pause := l.pauses[userID]
+	l.mu.Unlock()
+
+	if wait := time.Until(pause); wait > 0 {
+		if err := sleep(ctx, wait); err != nil {
+			return err
+		}
+	}
+
+	if err := l.global.Wait(ctx); err != nil {
+		return err
+	}
+	if err := user.Wait(ctx); err != nil {
+		return err
+	}
+
+	l.record(userID, time.Since(start))
+	return nil
+}
+
+// record updates the statistics with a request that waited for wait. Waits
+// below a millisecond are not counted as throttling.
+func (l *RateLimiter) record(userID string, wait time.Duration) {
+	if wait < time.Millisecond {
+		wait = 0
+	}
+
+	l.mu.Lock()
+	l.stats.Requests++
+	if wait > 0 {
+		l.stats.Throttled++
+		l.stats.TotalWait += wait
+		if wait > l.stats.MaxWait {
+			l.stats.MaxWait = wait
+		}
+	}
+	l.mu.Unlock()
+
+	if wait > 0 && l.limit.OnWait != nil {
+		l.limit.OnWait(userID, wait)
+	}
+}
+
+// Observe adapts the limiter to the rate limit headers of a response received
+// for userID. It does nothing unless RateLimit.AdaptToHeaders is set.
+func (l *RateLimiter) Observe(userID string, response *http.Response) {
+	if !l.limit.AdaptToHeaders {
+		return
+	}
+
+	now := time.Now()
+	var until time.Time
+
+	if response.StatusCode == http.StatusTooManyRequests {
+		if wait := parseRetryAfter(response.Header.Get("Retry-After"), now); wait > 0 {
+			until = now.Add(wait)
+		}
+	}
+
+	if response.Header.Get("X-RateLimit-Remaining") == "0" {
+		if reset, err := strconv.ParseInt(response.Header.Get("X-RateLimit-Reset"), 10, 64); err == nil {
+			// small values are a number of seconds, large ones a Unix time
+			resetAt := time.Unix(reset, 0)
+			if reset < 1e9 {
+				resetAt = now.Add(time.Duration(reset) * time.Second)
+			}
+			if resetAt.After(until) {
+				until = resetAt
+			}
+		}
+	}
+
+	if until.IsZero() {
+		return
+	}
+
+	l.mu.Lock()
+	if until.After(l.pauses[userID]) {
+		l.pauses[userID] = until
+	}
+	l.mu.Unlock()
+}
+
+// Stats returns a snapshot of the limiter's counters.
+func (l *RateLimiter) Stats() RateLimiterStats {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return l.stats
+}
+
+type contextKey int
+
+const userContextKey contextKey = iota
+
+// withUser returns a context carrying the itembase user a call is made for.
+func withUser(ctx context.Context, userID string) context.Context {
+	return context.WithValue(ctx, userContextKey, userID)
+}
+
+// UserFromContext returns the itembase user ID a call is made for, as passed
+// by a Client to ContextAPI.CallContext. It is empty for calls not made on
+// behalf of a user.
+func UserFromContext(ctx context.Context) string {
+	userID, _ := ctx.Value(userContextKey).(string)
+	return userID
+}
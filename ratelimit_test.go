@@ -0,0 +1,134 @@
+package itembase
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+// waitFor makes a request for userID pass limiter, and returns how long it
+// waited.
+func waitFor(t *testing.T, limiter *RateLimiter, userID string) time.Duration {
+	t.Helper()
+
+	start := time.Now()
+	if err := limiter.Wait(context.Background(), userID); err != nil {
+		t.Fatal(err)
+	}
+	return time.Since(start)
+}
+
+func TestRateLimiterPerUser(t *testing.T) {
+	var mu sync.Mutex
+	waits := make(map[string]int)
+
+	limiter := NewRateLimiter(RateLimit{
+		PerUser: 10,
+		OnWait: func(userID string, wait time.Duration) {
+			mu.Lock()
+			defer mu.Unlock()
+			waits[userID]++
+		},
+	})
+
+	waitFor(t, limiter, "a")
+	if waited := waitFor(t, limiter, "b"); waited > 50*time.Millisecond {
+		t.Errorf("user b waited %v for user a", waited)
+	}
+	if waited := waitFor(t, limiter, "a"); waited < 50*time.Millisecond {
+		t.Errorf("user a waited %v, want about 100ms", waited)
+	}
+
+	stats := limiter.Stats()
+	if stats.Requests != 3 || stats.Throttled != 1 {
+		t.Errorf("Stats() = %+v, want 3 requests and 1 throttled", stats)
+	}
+	if stats.MaxWait < 50*time.Millisecond || stats.TotalWait != stats.MaxWait {
+		t.Errorf("Stats() = %+v, want a single wait of about 100ms", stats)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if waits["a"] != 1 || waits["b"] != 0 {
+		t.Errorf("OnWait calls = %v, want 1 for user a", waits)
+	}
+}
+
+func TestRateLimiterGlobal(t *testing.T) {
+	limiter := NewRateLimiter(RateLimit{Global: 10, GlobalBurst: 2})
+
+	waitFor(t, limiter, "a")
+	waitFor(t, limiter, "b")
+	if waited := waitFor(t, limiter, "c"); waited < 50*time.Millisecond {
+		t.Errorf("third request waited %v, want about 100ms", waited)
+	}
+	if stats := limiter.Stats(); stats.Requests != 3 || stats.Throttled != 1 {
+		t.Errorf("Stats() = %+v, want 3 requests and 1 throttled", stats)
+	}
+}
+
+// response returns a response with the given status code and headers, in
+// name, value pairs.
+func response(code int, headers ...string) *http.Response {
+	recorder := httptest.NewRecorder()
+	for i := 0; i < len(headers); i += 2 {
+		recorder.Header().Set(headers[i], headers[i+1])
+	}
+	recorder.WriteHeader(code)
+	return recorder.Result()
+}
+
+func TestRateLimiterObserve(t *testing.T) {
+	reset := strconv.FormatInt(time.Now().Add(3*time.Second).Unix(), 10)
+
+	for _, test := range []struct {
+		name     string
+		response *http.Response
+		pause    time.Duration
+	}{
+		{"reset seconds", response(http.StatusOK, "X-RateLimit-Remaining", "0", "X-RateLimit-Reset", "2"), 2 * time.Second},
+		{"reset time", response(http.StatusOK, "X-RateLimit-Remaining", "0", "X-RateLimit-Reset", reset), 3 * time.Second},
+		{"remaining", response(http.StatusOK, "X-RateLimit-Remaining", "5", "X-RateLimit-Reset", "2"), 0},
+		{"retry after", response(http.StatusTooManyRequests, "Retry-After", "1"), time.Second},
+		{"retry after success", response(http.StatusOK, "Retry-After", "1"), 0},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			limiter := NewRateLimiter(RateLimit{AdaptToHeaders: true})
+			limiter.Observe("a", test.response)
+
+			pause := time.Until(limiter.pauses["a"])
+			if test.pause == 0 {
+				if pause > 0 {
+					t.Errorf("paused for %v", pause)
+				}
+				return
+			}
+			// Unix times are whole seconds
+			if pause < test.pause-time.Second-100*time.Millisecond || pause > test.pause {
+				t.Errorf("paused for %v, want %v", pause, test.pause)
+			}
+
+			ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+			defer cancel()
+			if err := limiter.Wait(ctx, "a"); !errors.Is(err, context.DeadlineExceeded) {
+				t.Errorf("Wait() = %v while paused, want context.DeadlineExceeded", err)
+			}
+			if waited := waitFor(t, limiter, "b"); waited > 10*time.Millisecond {
+				t.Errorf("user b waited %v while user a was paused", waited)
+			}
+		})
+	}
+}
+
+func TestRateLimiterObserveDisabled(t *testing.T) {
+	limiter := NewRateLimiter(RateLimit{})
+	limiter.Observe("a", response(http.StatusTooManyRequests, "Retry-After", "1"))
+
+	if waited := waitFor(t, limiter, "a"); waited > 10*time.Millisecond {
+		t.Errorf("waited %v without AdaptToHeaders", waited)
+	}
+}
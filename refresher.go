@@ -0,0 +1,223 @@
+package itembase
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"golang.org/x/oauth2"
+)
+
+var (
+	// ErrNoTokenLister is returned by a TokenRefresher whose Config has no
+	// TokenLister.
+	ErrNoTokenLister = errors.New("itembase: no TokenLister")
+
+	// ErrTokenRevoked is reported for users whose refresh token was
+	// rejected by itembase. They have to authorize the application again.
+	ErrTokenRevoked = errors.New("itembase: refresh token revoked")
+)
+
+// TokenRefresherSettings configures a TokenRefresher. Zero values are replaced
+// by the defaults noted on each field.
+type TokenRefresherSettings struct {
+	// Interval is the time between two scans of the token store. Defaults
+	// to five minutes.
+	Interval time.Duration
+
+	// RefreshBefore is how long before their expiry tokens are refreshed.
+	// It should be longer than Interval. Defaults to fifteen minutes.
+	RefreshBefore time.Duration
+
+	// Concurrency is the number of tokens refreshed at the same time.
+	// Defaults to 4.
+	Concurrency int
+
+	// OnRevoked, if set, is called for every user whose refresh token was
+	// revoked, with an error wrapping ErrTokenRevoked.
+	OnRevoked func(userID string, err error)
+
+	// OnError, if set, is called for every user whose token could not be
+	// loaded, refreshed or saved for another reason.
+	OnError func(userID string, err error)
+}
+
+// RefreshResult describes a scan of the token store by a TokenRefresher.
+type RefreshResult struct {
+	// Checked is the number of users whose token was looked at.
+	Checked int
+
+	// Refreshed, Revoked and Failed are the users whose token was
+	// refreshed, whose refresh token was revoked and whose token could not
+	// be refreshed for another reason.
+	Refreshed []string
+	Revoked   []string
+	Failed    map[string]error
+}
+
+// A TokenRefresher refreshes the tokens of connected shops in the background,
+// before they expire, so requests do not pay the refresh latency and revoked
+// authorizations are found outside of syncs. It needs the TokenLoader,
+// TokenSaver and TokenLister of the Config.
+//
+//	refresher := itembase.NewTokenRefresher(config, itembase.TokenRefresherSettings{
+//		OnRevoked: func(userID string, err error) { notifyShop(userID) },
+//	})
+//	go refresher.Run(ctx)
+type TokenRefresher struct {
+	client   *client
+	settings TokenRefresherSettings
+}
+
+// NewTokenRefresher returns a TokenRefresher for the tokens of config.
+func NewTokenRefresher(config Config, settings TokenRefresherSettings) *TokenRefresher {
+	if settings.Interval <= 0 {
+		settings.Interval = 5 * time.Minute
+	}
+	if settings.RefreshBefore <= 0 {
+		settings.RefreshBefore = 15 * time.Minute
+	}
+	if settings.Concurrency <= 0 {
+		settings.Concurrency = 4
+	}
+
+	return &TokenRefresher{client: New(config, nil).(*client), settings: settings}
+}
+
+// Run scans the token store every Interval until ctx is done, and returns
+// ctx.Err(). Failures are reported through OnRevoked and OnError.
+func (r *TokenRefresher) Run(ctx context.Context) error {
+	ticker := time.NewTicker(r.settings.Interval)
+	defer ticker.Stop()
+
+	for {
+		if _, err := r.RefreshOnce(ctx); err != nil && ctx.Err() == nil {
+			r.client.options.logger().Error("listing tokens failed", "error", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// RefreshOnce refreshes all tokens expiring within RefreshBefore. It only
+// returns an error if the users could not be listed; failures for single
+// users are part of the result.
+func (r *TokenRefresher) RefreshOnce(ctx context.Context) (*RefreshResult, error) {
+	lister := r.client.options.TokenHandler.TokenLister
+	if lister == nil {
+		return nil, ErrNoTokenLister
+	}
+
+	userIDs, err := lister()
+	if err != nil {
+		return nil, err
+	}
+
+	var (
+		mu     sync.Mutex
+		wg     sync.WaitGroup
+		slots  = make(chan struct{}, r.settings.Concurrency)
+		result = &RefreshResult{Failed: make(map[string]error)}
+	)
+
+	for _, userID := range userIDs {
+		if userID == AppTokenKey {
+			continue
+		}
+
+		select {
+		case <-ctx.Done():
+			wg.Wait()
+			return result, ctx.Err()
+		case slots <- struct{}{}:
+		}
+
+		wg.Add(1)
+		go func(userID string) {
+			defer wg.Done()
+			defer func() { <-slots }()
+
+			refreshed, err := r.refresh(ctx, userID)
+
+			mu.Lock()
+			defer mu.Unlock()
+
+			result.Checked++
+			switch {
+			case errors.Is(err, ErrTokenRevoked):
+				result.Revoked = append(result.Revoked, userID)
+			case err != nil:
+				result.Failed[userID] = err
+			case refreshed:
+				result.Refreshed = append(result.Refreshed, userID)
+			}
+		}(userID)
+	}
+	wg.Wait()
+
+	return result, nil
+}
+
+// refresh refreshes the token of userID if it expires within RefreshBefore,
+// and reports whether it did.
+func (r *TokenRefresher) refresh(ctx context.Context, userID string) (refreshed bool, err error) {
+	defer func() {
+		switch {
+		case errors.Is(err, ErrTokenRevoked):
+			if r.settings.OnRevoked != nil {
+				r.settings.OnRevoked(userID, err)
+			}
+		case err != nil:
+			if r.settings.OnError != nil {
+				r.settings.OnError(userID, err)
+			}
+		}
+	}()
+
+	token, err := r.client.GetCachedToken(userID)
+	if err != nil || token == nil || token.RefreshToken == "" || token.Expiry.IsZero() {
+		return false, err
+	}
+	if time.Until(token.Expiry) > r.settings.RefreshBefore {
+		return false, nil
+	}
+
+	source := r.client.newConf().TokenSource(r.client.oauthContext(ctx), &oauth2.Token{RefreshToken: token.RefreshToken})
+	fresh, err := source.Token()
+	if err != nil {
+		if !isInvalidGrant(err) {
+			return false, fmt.Errorf("%w: %w", ErrTokenExchange, err)
+		}
+
+		// the refresh token may have been rotated by another process in
+		// the meantime
+		if current, loadErr := r.client.GetCachedToken(userID); loadErr == nil && current != nil && current.RefreshToken != token.RefreshToken {
+			return false, nil
+		}
+		return false, fmt.Errorf("%w: %w", ErrTokenRevoked, err)
+	}
+
+	if grantedScope(fresh) == "" && grantedScope(token) != "" {
+		fresh = fresh.WithExtra(map[string]interface{}{"scope": grantedScope(token)})
+	}
+
+	if err := r.client.SaveToken(userID, fresh); err != nil {
+		return false, fmt.Errorf("%w: %w", ErrTokenSave, err)
+	}
+
+	r.client.options.logger().Debug("refreshed token", "user", userID, "expiry", fresh.Expiry)
+	return true, nil
+}
+
+// isInvalidGrant reports whether err is an OAuth2 invalid_grant error, as
+// returned for revoked or expired refresh tokens.
+func isInvalidGrant(err error) bool {
+	var retrieveErr *oauth2.RetrieveError
+	return errors.As(err, &retrieveErr) && retrieveErr.ErrorCode == "invalid_grant"
+}
@@ -0,0 +1,126 @@
+package itembase_test
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"golang.org/x/oauth2"
+	"gopkg.in/saasbuilders/itembase.v0"
+	"gopkg.in/saasbuilders/itembase.v0/itembasetest"
+)
+
+// newRefresherStore returns a fake itembase knowing the given users, and a
+// store holding a token of each, expiring after the given time.
+func newRefresherStore(t *testing.T, expiries map[string]time.Duration) (*itembasetest.Server, *memoryTokens) {
+	t.Helper()
+
+	server := itembasetest.NewServer()
+	t.Cleanup(server.Close)
+
+	store := newMemoryTokens()
+	for userID, expiry := range expiries {
+		server.AddUser(itembase.User{UUID: userID})
+		token, err := server.Token(userID, "connection.product")
+		if err != nil {
+			t.Fatal(err)
+		}
+		token.Expiry = time.Now().Add(expiry)
+		store.Handler().TokenSaver(userID, token)
+	}
+	return server, store
+}
+
+func TestRefreshOnce(t *testing.T) {
+	server, store := newRefresherStore(t, map[string]time.Duration{
+		"expiring": 5 * time.Minute,
+		"valid":    time.Hour,
+	})
+	expiring := store.get("expiring")
+
+	config := server.Config()
+	config.TokenHandler = store.Handler()
+	result, err := itembase.NewTokenRefresher(config, itembase.TokenRefresherSettings{}).RefreshOnce(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if result.Checked != 2 || !reflect.DeepEqual(result.Refreshed, []string{"expiring"}) || len(result.Revoked) != 0 || len(result.Failed) != 0 {
+		t.Errorf("RefreshOnce() = %+v, want expiring refreshed", result)
+	}
+	fresh := store.get("expiring")
+	if fresh.RefreshToken == expiring.RefreshToken || time.Until(fresh.Expiry) < 30*time.Minute {
+		t.Errorf("saved token %+v, want a fresh one", fresh)
+	}
+	if scope := fresh.Extra("scope"); scope != "connection.product" {
+		t.Errorf("saved scope = %v, want connection.product", scope)
+	}
+}
+
+func TestRefreshOnceRevoked(t *testing.T) {
+	server, store := newRefresherStore(t, map[string]time.Duration{"revoked": time.Minute})
+	store.Handler().TokenSaver("revoked", &oauth2.Token{AccessToken: "a", RefreshToken: "bogus", Expiry: time.Now()})
+
+	var mu sync.Mutex
+	var revoked []string
+	config := server.Config()
+	config.TokenHandler = store.Handler()
+	refresher := itembase.NewTokenRefresher(config, itembase.TokenRefresherSettings{
+		OnRevoked: func(userID string, err error) {
+			mu.Lock()
+			defer mu.Unlock()
+			if !errors.Is(err, itembase.ErrTokenRevoked) {
+				t.Errorf("OnRevoked(%q, %v), want ErrTokenRevoked", userID, err)
+			}
+			revoked = append(revoked, userID)
+		},
+		OnError: func(userID string, err error) { t.Errorf("OnError(%q, %v)", userID, err) },
+	})
+
+	result, err := refresher.RefreshOnce(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(result.Revoked, []string{"revoked"}) || len(result.Refreshed) != 0 {
+		t.Errorf("RefreshOnce() = %+v, want revoked", result)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if !reflect.DeepEqual(revoked, []string{"revoked"}) {
+		t.Errorf("OnRevoked called for %q", revoked)
+	}
+}
+
+func TestRefreshOnceRotatedConcurrently(t *testing.T) {
+	server, store := newRefresherStore(t, map[string]time.Duration{"rotated": time.Minute})
+	stale := &oauth2.Token{AccessToken: "a", RefreshToken: "used", Expiry: time.Now()}
+
+	// the refresher loads a refresh token another process rotated since
+	var mu sync.Mutex
+	loads := 0
+	config := server.Config()
+	config.TokenHandler = store.Handler()
+	config.TokenHandler.TokenLoader = func(userID string) (*oauth2.Token, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		if loads++; loads == 1 {
+			return stale, nil
+		}
+		return store.get(userID), nil
+	}
+	refresher := itembase.NewTokenRefresher(config, itembase.TokenRefresherSettings{
+		OnRevoked: func(userID string, err error) { t.Errorf("OnRevoked(%q, %v)", userID, err) },
+		OnError:   func(userID string, err error) { t.Errorf("OnError(%q, %v)", userID, err) },
+	})
+
+	result, err := refresher.RefreshOnce(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Checked != 1 || len(result.Revoked) != 0 || len(result.Refreshed) != 0 || len(result.Failed) != 0 {
+		t.Errorf("RefreshOnce() = %+v, want nothing to report", result)
+	}
+}
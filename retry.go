@@ -0,0 +1,180 @@
+package itembase
+
+import (
+	"context"
+	"errors"
+	"math"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// A RetryPolicy controls how the default API implementation retries failed
+// calls. Delays grow exponentially from BaseDelay up to MaxDelay, randomised
+// by Jitter.
+type RetryPolicy struct {
+	// MaxAttempts is the total number of attempts made for a call, including
+	// the first one. Values below 2 disable retries.
+	MaxAttempts int
+
+	// BaseDelay is the delay before the first retry. It doubles with every
+	// further retry.
+	BaseDelay time.Duration
+
+	// MaxDelay caps the delay between two attempts. Zero means no cap.
+	MaxDelay time.Duration
+
+	// MaxRetryAfter caps the delay itembase may request through
+	// Retry-After. A call asked to wait longer is not retried: it fails
+	// with the *APIError, whose RetryAfter tells when to try again. It
+	// defaults to MaxDelay; if both are zero, any delay is waited for.
+	MaxRetryAfter time.Duration
+
+	// Jitter is the fraction, between 0 and 1, of each delay that is
+	// randomised, to keep many clients from retrying in lockstep.
+	Jitter float64
+
+	// StatusCodes, if not nil, lists the HTTP status codes of API errors
+	// that are retried, replacing the classification of Retryable for
+	// *APIError values.
+	StatusCodes []int
+
+	// Retryable reports whether a failed call should be retried. It
+	// defaults to IsRetryable.
+	Retryable func(err error) bool
+
+	// IgnoreRetryAfter disables waiting for the delay requested by the
+	// Retry-After header of 429 and 503 responses.
+	IgnoreRetryAfter bool
+
+	// Methods lists the HTTP methods of calls that are retried after any
+	// retryable failure. It defaults to the idempotent methods GET, HEAD,
+	// OPTIONS and TRACE. Calls with other methods are only retried after a
+	// 429 response, which rejects a request without processing it.
+	Methods []string
+}
+
+// idempotentMethods are the methods retried when RetryPolicy.Methods is nil.
+var idempotentMethods = []string{"GET", "HEAD", "OPTIONS", "TRACE"}
+
+// DefaultRetryPolicy is used when Config.RetryPolicy is nil.
+var DefaultRetryPolicy = RetryPolicy{
+	MaxAttempts: 4,
+	BaseDelay:   500 * time.Millisecond,
+	MaxDelay:    30 * time.Second,
+	Jitter:      0.5,
+}
+
+// retryPolicy returns the configured RetryPolicy, or DefaultRetryPolicy.
+func (options Config) retryPolicy() RetryPolicy {
+	if options.RetryPolicy == nil {
+		return DefaultRetryPolicy
+	}
+	return *options.RetryPolicy
+}
+
+// retries reports whether a call with method that failed with err should be
+// retried under the policy.
+func (policy RetryPolicy) retries(method string, err error) bool {
+	if !policy.retryable(err) {
+		return false
+	}
+
+	methods := policy.Methods
+	if methods == nil {
+		methods = idempotentMethods
+	}
+	for _, m := range methods {
+		if strings.EqualFold(m, method) {
+			return true
+		}
+	}
+
+	var apiErr *APIError
+	return errors.As(err, &apiErr) && apiErr.StatusCode == http.StatusTooManyRequests
+}
+
+// retryable reports whether err should be retried under the policy.
+func (policy RetryPolicy) retryable(err error) bool {
+	var apiErr *APIError
+	if policy.StatusCodes != nil && errors.As(err, &apiErr) {
+		for _, code := range policy.StatusCodes {
+			if apiErr.StatusCode == code {
+				return true
+			}
+		}
+		return false
+	}
+
+	if policy.Retryable != nil {
+		return policy.Retryable(err)
+	}
+	return IsRetryable(err)
+}
+
+// delay returns how long to wait before the given retry, counted from 1,
+// after a call failed with err. It reports false if itembase asked for a
+// longer delay than MaxRetryAfter.
+func (policy RetryPolicy) delay(retry int, err error) (time.Duration, bool) {
+	delay := policy.BaseDelay
+	for i := 1; i < retry && delay < math.MaxInt64/2; i++ {
+		if policy.MaxDelay > 0 && delay >= policy.MaxDelay {
+			break
+		}
+		delay *= 2
+	}
+	if policy.MaxDelay > 0 && delay > policy.MaxDelay {
+		delay = policy.MaxDelay
+	}
+
+	if policy.Jitter > 0 {
+		delay -= time.Duration(rand.Float64() * policy.Jitter * float64(delay))
+	}
+
+	var apiErr *APIError
+	if !policy.IgnoreRetryAfter && errors.As(err, &apiErr) && apiErr.RetryAfter > delay {
+		limit := policy.MaxRetryAfter
+		if limit <= 0 {
+			limit = policy.MaxDelay
+		}
+		if limit > 0 && apiErr.RetryAfter > limit {
+			return 0, false
+		}
+		delay = apiErr.RetryAfter
+	}
+
+	return delay, true
+}
+
+// sleep waits for d, or until ctx is done.
+func sleep(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// parseRetryAfter parses the value of a Retry-After header, given either in
+// seconds or as an HTTP date.
+func parseRetryAfter(value string, now time.Time) time.Duration {
+	if value == "" {
+		return 0
+	}
+
+	if seconds, err := strconv.Atoi(value); err == nil && seconds > 0 {
+		return time.Duration(seconds) * time.Second
+	}
+
+	if date, err := http.ParseTime(value); err == nil && date.After(now) {
+		return date.Sub(now)
+	}
+
+	return 0
+}
@@ -0,0 +1,214 @@
+package itembase
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// flakyServer answers requests with the given status codes in turn, setting
+// Retry-After to retryAfter if not empty, and with 200 once they are used up.
+func flakyServer(t *testing.T, retryAfter string, statuses ...int) (*httptest.Server, *int32) {
+	t.Helper()
+
+	var requests int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := int(atomic.AddInt32(&requests, 1))
+		if n <= len(statuses) {
+			if retryAfter != "" {
+				w.Header().Set("Retry-After", retryAfter)
+			}
+			w.WriteHeader(statuses[n-1])
+			return
+		}
+		w.Write([]byte(`{"uuid":"13ac2c74"}`))
+	}))
+	t.Cleanup(server.Close)
+
+	return server, &requests
+}
+
+func newFlakyAPI(server *httptest.Server, policy RetryPolicy) *itembaseAPI {
+	return newAPI(Config{HTTPClient: server.Client(), RetryPolicy: &policy})
+}
+
+var fastRetries = RetryPolicy{MaxAttempts: 5, BaseDelay: time.Millisecond, MaxDelay: 10 * time.Millisecond}
+
+func TestRetryFlakyServer(t *testing.T) {
+	server, requests := flakyServer(t, "", 429, 503, 503)
+
+	var user User
+	err := newFlakyAPI(server, fastRetries).CallContext(context.Background(), "GET", server.URL, "token", nil, nil, &user)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user.UUID != "13ac2c74" {
+		t.Errorf("UUID = %q", user.UUID)
+	}
+	if *requests != 4 {
+		t.Errorf("%d requests, want 4", *requests)
+	}
+}
+
+func TestRetryMaxAttempts(t *testing.T) {
+	server, requests := flakyServer(t, "", 503, 503, 503, 503)
+
+	policy := fastRetries
+	policy.MaxAttempts = 3
+	err := newFlakyAPI(server, policy).CallContext(context.Background(), "GET", server.URL, "token", nil, nil, nil)
+
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusServiceUnavailable {
+		t.Fatalf("err = %v, want a 503 *APIError", err)
+	}
+	if want := "itembase: GET " + server.URL + ": 503 Service Unavailable"; err.Error() != want {
+		t.Errorf("Error() = %q, want %q", err.Error(), want)
+	}
+	if *requests != 3 {
+		t.Errorf("%d requests, want 3", *requests)
+	}
+}
+
+func TestRetryAfter(t *testing.T) {
+	server, requests := flakyServer(t, "1", 429)
+
+	policy := fastRetries
+	policy.MaxRetryAfter = 2 * time.Second
+	start := time.Now()
+	if err := newFlakyAPI(server, policy).CallContext(context.Background(), "GET", server.URL, "token", nil, nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	if waited := time.Since(start); waited < time.Second {
+		t.Errorf("retried after %v, want at least 1s", waited)
+	}
+	if *requests != 2 {
+		t.Errorf("%d requests, want 2", *requests)
+	}
+}
+
+func TestRetryAfterTooLong(t *testing.T) {
+	server, requests := flakyServer(t, "86400", 429)
+
+	start := time.Now()
+	err := newFlakyAPI(server, fastRetries).CallContext(context.Background(), "GET", server.URL, "token", nil, nil, nil)
+
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) || apiErr.RetryAfter != 24*time.Hour {
+		t.Fatalf("err = %v, want a *APIError with RetryAfter 24h", err)
+	}
+	if *requests != 1 {
+		t.Errorf("%d requests, want 1", *requests)
+	}
+	if waited := time.Since(start); waited > time.Second {
+		t.Errorf("returned after %v", waited)
+	}
+}
+
+func TestRetryDeadline(t *testing.T) {
+	server, _ := flakyServer(t, "", 503, 503, 503, 503)
+
+	policy := fastRetries
+	policy.BaseDelay, policy.MaxDelay = time.Second, time.Second
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	err := newFlakyAPI(server, policy).CallContext(ctx, "GET", server.URL, "token", nil, nil, nil)
+
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("err = %v, want context.DeadlineExceeded", err)
+	}
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("err = %v, want the last 503 *APIError kept", err)
+	}
+	if IsRetryable(err) {
+		t.Error("IsRetryable() = true after the deadline passed")
+	}
+}
+
+func TestRetryNonIdempotent(t *testing.T) {
+	server, requests := flakyServer(t, "", 503)
+	if err := newFlakyAPI(server, fastRetries).CallContext(context.Background(), "POST", server.URL, "token", nil, nil, nil); err == nil {
+		t.Fatal("POST was retried after a 503")
+	}
+	if *requests != 1 {
+		t.Errorf("%d requests, want 1", *requests)
+	}
+
+	server, requests = flakyServer(t, "", 429)
+	if err := newFlakyAPI(server, fastRetries).CallContext(context.Background(), "POST", server.URL, "token", nil, nil, nil); err != nil {
+		t.Fatalf("POST was not retried after a 429: %v", err)
+	}
+	if *requests != 2 {
+		t.Errorf("%d requests, want 2", *requests)
+	}
+}
+
+func TestRetryDelay(t *testing.T) {
+	for _, test := range []struct {
+		maxDelay time.Duration
+		want     []time.Duration
+	}{
+		{0, []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 8 * time.Second}},
+		{3 * time.Second, []time.Duration{time.Second, 2 * time.Second, 3 * time.Second, 3 * time.Second}},
+	} {
+		policy := RetryPolicy{BaseDelay: time.Second, MaxDelay: test.maxDelay}
+		for i, want := range test.want {
+			if got, _ := policy.delay(i+1, errors.New("flaky")); got != want {
+				t.Errorf("MaxDelay %v: retry %d: delay = %v, want %v", test.maxDelay, i+1, got, want)
+			}
+		}
+	}
+}
+
+// recordingLogger keeps the values logged with the "error" key.
+type recordingLogger struct {
+	mu     sync.Mutex
+	errors []string
+}
+
+func (l *recordingLogger) record(keyvals []interface{}) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for i := 0; i+1 < len(keyvals); i += 2 {
+		if keyvals[i] == "error" {
+			l.errors = append(l.errors, fmt.Sprint(keyvals[i+1]))
+		}
+	}
+}
+
+func (l *recordingLogger) Debug(msg string, keyvals ...interface{}) { l.record(keyvals) }
+func (l *recordingLogger) Info(msg string, keyvals ...interface{})  { l.record(keyvals) }
+func (l *recordingLogger) Warn(msg string, keyvals ...interface{})  { l.record(keyvals) }
+func (l *recordingLogger) Error(msg string, keyvals ...interface{}) { l.record(keyvals) }
+
+func TestTransportErrorRedacted(t *testing.T) {
+	server := httptest.NewServer(http.NotFoundHandler())
+	server.Close()
+
+	logger := new(recordingLogger)
+	api := newAPI(Config{HTTPClient: server.Client(), Logger: logger, RetryPolicy: &fastRetries})
+	err := api.CallContext(context.Background(), "GET", server.URL+"/users", "token", nil, map[string]string{"created_at_from": "secret"}, nil)
+
+	var urlErr *url.Error
+	if !errors.As(err, &urlErr) {
+		t.Fatalf("err = %v, want a *url.Error", err)
+	}
+	logger.mu.Lock()
+	defer logger.mu.Unlock()
+	if len(logger.errors) == 0 {
+		t.Fatal("transport failure not logged")
+	}
+	for _, logged := range append(logger.errors, err.Error()) {
+		if strings.Contains(logged, "secret") || strings.Contains(logged, "created_at_from") {
+			t.Errorf("query parameters in %q", logged)
+		}
+	}
+}
@@ -0,0 +1,147 @@
+package itembase
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"golang.org/x/oauth2"
+)
+
+// ErrMissingScope is returned, without calling itembase, for calls to an
+// endpoint needing a scope that was not granted with the user's token. Use
+// UpgradeScopes to ask the user for it.
+//
+//	var missing itembase.ErrMissingScope
+//	if errors.As(err, &missing) {
+//		storeRef, err = storeRef.UpgradeScopes(ctx, missing.Scope)
+//	}
+type ErrMissingScope struct {
+	Scope string
+}
+
+func (e ErrMissingScope) Error() string {
+	return "itembase: missing scope " + e.Scope
+}
+
+// entityScopes are the scopes needed for the entity collections.
+var entityScopes = map[string]string{
+	"transactions": "connection.transaction",
+	"products":     "connection.product",
+	"buyers":       "connection.buyer",
+	"profiles":     "connection.profile",
+}
+
+// grantedScopes returns the scopes granted with token, or nil if the token
+// response did not include them.
+func grantedScopes(token *oauth2.Token) map[string]bool {
+	scope := grantedScope(token)
+	if scope == "" {
+		return nil
+	}
+
+	granted := make(map[string]bool)
+	for _, s := range strings.Fields(scope) {
+		granted[s] = true
+	}
+	return granted
+}
+
+// checkScope returns ErrMissingScope if the client's endpoint needs a scope
+// its token was not granted. Nothing is checked if the granted scopes are
+// unknown.
+func (c *client) checkScope() error {
+	if c.scope == "" || c.granted == nil || c.granted[c.scope] {
+		return nil
+	}
+	return ErrMissingScope{Scope: c.scope}
+}
+
+// GrantedScopes returns the scopes granted with the user's token, or nil if
+// they are unknown.
+func (c *client) GrantedScopes() []string {
+	if c.granted == nil {
+		return nil
+	}
+
+	scopes := make([]string, 0, len(c.granted))
+	for scope := range c.granted {
+		scopes = append(scopes, scope)
+	}
+	sort.Strings(scopes)
+	return scopes
+}
+
+// UpgradeScopes asks the user to grant the scopes not granted yet, through the
+// TokenPermissions handler with an authorization URL requesting only those,
+// and returns a client using the new token, which is saved as returned by
+// itembase. The returned client checks calls against the scope of the token
+// response or, if it has none, against the scopes granted before and the ones
+// requested.
+func (c *client) UpgradeScopes(ctx context.Context, scopes ...string) (Client, error) {
+	if c.err != nil {
+		return nil, c.err
+	}
+	if c.user == "" {
+		return nil, fmt.Errorf("itembase: UpgradeScopes needs a user client")
+	}
+
+	var missing []string
+	for _, scope := range scopes {
+		if c.granted == nil || !c.granted[scope] {
+			missing = append(missing, scope)
+		}
+	}
+	if len(missing) == 0 {
+		return c, nil
+	}
+
+	state, err := newState()
+	if err != nil {
+		return nil, err
+	}
+	opts, verifier, err := c.authCodeOptions()
+	if err != nil {
+		return nil, err
+	}
+
+	config := c.newConf()
+	config.Scopes = missing
+
+	authcode, err := c.GiveTokenPermissions(config.AuthCodeURL(state, opts...))
+	if err != nil {
+		return nil, err
+	}
+
+	var exchangeOpts []oauth2.AuthCodeOption
+	if verifier != "" {
+		exchangeOpts = append(exchangeOpts, PKCEVerifier(verifier))
+	}
+	token, err := c.HandleOAuthCodeContext(ctx, authcode, exchangeOpts...)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := c.SaveToken(c.user, token); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrTokenSave, err)
+	}
+
+	granted := grantedScopes(token)
+	if granted == nil {
+		// a response without scope grants the requested ones; that the
+		// earlier ones are kept is only assumed, so it is not saved
+		granted = make(map[string]bool)
+		for scope := range c.granted {
+			granted[scope] = true
+		}
+		for _, scope := range missing {
+			granted[scope] = true
+		}
+	}
+
+	d := c.clone()
+	d.auth = token.AccessToken
+	d.granted = granted
+	return d, nil
+}
@@ -0,0 +1,134 @@
+package itembase_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+
+	"gopkg.in/saasbuilders/itembase.v0"
+	"gopkg.in/saasbuilders/itembase.v0/itembasetest"
+)
+
+// newScopedUser returns a client for a user that granted connection.product
+// only. Authorizations asked for through TokenPermissions are granted with the
+// scope returned by grant for the requested one.
+func newScopedUser(t *testing.T, grant func(requested string) string) (itembase.Client, *memoryTokens) {
+	t.Helper()
+
+	server := itembasetest.NewServer()
+	t.Cleanup(server.Close)
+	if err := server.Seed(itembasetest.Fixture{
+		User:     itembase.User{UUID: testUserID},
+		Products: []itembase.Product{{ID: "p"}},
+		Buyers:   []itembase.Buyer{{ID: "b"}},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	store := newMemoryTokens()
+	token, err := server.Token(testUserID, "connection.product")
+	if err != nil {
+		t.Fatal(err)
+	}
+	store.Handler().TokenSaver(testUserID, token)
+
+	config := server.Config()
+	config.RedirectURL = "https://app.example/oauth/callback"
+	config.TokenHandler = store.Handler()
+	config.TokenHandler.TokenPermissions = consent(server, grant)
+
+	storeRef, err := itembase.New(config, nil).ForUser(context.Background(), testUserID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return storeRef, store
+}
+
+// consent returns a TokenPermissions handler consenting on server, granting
+// the scope returned by grant for the requested one.
+func consent(server *itembasetest.Server, grant func(requested string) string) func(authURL string) (string, error) {
+	return func(authURL string) (string, error) {
+		u, err := url.Parse(authURL)
+		if err != nil {
+			return "", err
+		}
+		query := u.Query()
+		query.Set("scope", grant(query.Get("scope")))
+		u.RawQuery = query.Encode()
+
+		browser := server.Client()
+		browser.CheckRedirect = func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }
+		response, err := browser.Get(u.String())
+		if err != nil {
+			return "", err
+		}
+		response.Body.Close()
+
+		redirect, err := url.Parse(response.Header.Get("Location"))
+		if err != nil {
+			return "", err
+		}
+		return redirect.Query().Get("code"), nil
+	}
+}
+
+func TestMissingScope(t *testing.T) {
+	storeRef, _ := newScopedUser(t, func(requested string) string { return requested })
+
+	if _, err := storeRef.Products().Get(); err != nil {
+		t.Fatal(err)
+	}
+
+	var missing itembase.ErrMissingScope
+	if _, err := storeRef.Buyers().Get(); !errors.As(err, &missing) || missing.Scope != "connection.buyer" {
+		t.Fatalf("Buyers(): err = %v, want ErrMissingScope for connection.buyer", err)
+	}
+
+	upgraded, err := storeRef.UpgradeScopes(context.Background(), missing.Scope)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := upgraded.Buyers().Get(); err != nil {
+		t.Errorf("Buyers() after UpgradeScopes: %v", err)
+	}
+	if _, err := upgraded.Products().Get(); err != nil {
+		t.Errorf("Products() after UpgradeScopes: %v", err)
+	}
+}
+
+func TestUpgradeScopesDenied(t *testing.T) {
+	// the user grants another scope than the requested one
+	storeRef, store := newScopedUser(t, func(string) string { return "user.minimal" })
+
+	upgraded, err := storeRef.UpgradeScopes(context.Background(), "connection.buyer")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var missing itembase.ErrMissingScope
+	if _, err := upgraded.Buyers().Get(); !errors.As(err, &missing) {
+		t.Errorf("Buyers(): err = %v, want ErrMissingScope", err)
+	}
+	if scope, _ := store.get(testUserID).Extra("scope").(string); strings.Contains(scope, "connection.buyer") {
+		t.Errorf("saved scope %q claims connection.buyer", scope)
+	}
+}
+
+func TestUpgradeScopesWithoutScope(t *testing.T) {
+	// the fake itembase grants everything without reporting a scope
+	storeRef, store := newScopedUser(t, func(string) string { return "" })
+
+	upgraded, err := storeRef.UpgradeScopes(context.Background(), "connection.buyer")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := upgraded.Buyers().Get(); err != nil {
+		t.Errorf("Buyers() after UpgradeScopes: %v", err)
+	}
+	if scope := store.get(testUserID).Extra("scope"); scope != nil {
+		t.Errorf("saved scope %q that itembase did not report", scope)
+	}
+}
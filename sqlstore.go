@@ -0,0 +1,251 @@
+package itembase
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/oauth2"
+)
+
+// SQLDialect selects the SQL syntax used by a SQLTokenStore.
+type SQLDialect int
+
+const (
+	// SQLite uses ? placeholders, as expected by SQLite drivers.
+	SQLite SQLDialect = iota
+	// Postgres uses $1 placeholders, as expected by PostgreSQL drivers.
+	Postgres
+)
+
+// SQLTokenStoreSettings configures a SQLTokenStore.
+type SQLTokenStoreSettings struct {
+	Dialect SQLDialect
+
+	// Table is the name of the token table. Defaults to itembase_tokens.
+	Table string
+}
+
+// A SQLTokenStore persists the OAuth2 token of each user in a database table,
+// together with the granted scopes, the token expiry and the time it was last
+// refreshed. It works with any database/sql driver for the configured
+// dialect; the driver must be imported by the application.
+//
+//	db, err := sql.Open("sqlite", "tokens.db")
+//	store, err := itembase.NewSQLTokenStore(db, itembase.SQLTokenStoreSettings{Dialect: itembase.SQLite})
+//	err = store.Migrate(ctx)
+//	config.TokenHandler = store.Handler()
+type SQLTokenStore struct {
+	db       *sql.DB
+	settings SQLTokenStoreSettings
+}
+
+var sqlIdentifier = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*(\.[A-Za-z_][A-Za-z0-9_]*)?$`)
+
+// NewSQLTokenStore returns a SQLTokenStore using db. The table is not created
+// until Migrate is called.
+func NewSQLTokenStore(db *sql.DB, settings SQLTokenStoreSettings) (*SQLTokenStore, error) {
+	if settings.Table == "" {
+		settings.Table = "itembase_tokens"
+	}
+	if !sqlIdentifier.MatchString(settings.Table) {
+		return nil, fmt.Errorf("itembase: invalid token table name %q", settings.Table)
+	}
+	return &SQLTokenStore{db: db, settings: settings}, nil
+}
+
+// Migrate creates the token table if it does not exist yet.
+func (s *SQLTokenStore) Migrate(ctx context.Context) error {
+	timestamp := "TIMESTAMP"
+	if s.settings.Dialect == Postgres {
+		timestamp = "TIMESTAMPTZ"
+	}
+
+	_, err := s.db.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS `+s.settings.Table+` (
+		user_id       VARCHAR(255) NOT NULL PRIMARY KEY,
+		access_token  TEXT NOT NULL,
+		token_type    VARCHAR(64) NOT NULL DEFAULT '',
+		refresh_token TEXT NOT NULL DEFAULT '',
+		expiry        `+timestamp+` NULL,
+		scopes        TEXT NOT NULL DEFAULT '',
+		last_refresh  `+timestamp+` NOT NULL
+	)`)
+	return err
+}
+
+// Handler returns the token handlers for use as Config.TokenHandler.
+func (s *SQLTokenStore) Handler() ItembaseTokens {
+	return ItembaseTokens{
+		TokenLoader:  s.Load,
+		TokenSaver:   s.Save,
+		TokenLister:  s.List,
+		TokenDeleter: s.Delete,
+	}
+}
+
+// Delete removes the token stored for userID, if any.
+func (s *SQLTokenStore) Delete(userID string) error {
+	return s.DeleteContext(context.Background(), userID)
+}
+
+// DeleteContext is like Delete, with a context for the statement.
+func (s *SQLTokenStore) DeleteContext(ctx context.Context, userID string) error {
+	_, err := s.db.ExecContext(ctx, s.query(`DELETE FROM `+s.settings.Table+` WHERE user_id = ?`), userID)
+	return err
+}
+
+// List returns the IDs of all users with a stored token. The application
+// token is not listed.
+func (s *SQLTokenStore) List() ([]string, error) {
+	return s.ListContext(context.Background())
+}
+
+// ListContext is like List, with a context for the query.
+func (s *SQLTokenStore) ListContext(ctx context.Context) (userIDs []string, err error) {
+	rows, err := s.db.QueryContext(ctx, s.query(`SELECT user_id FROM `+s.settings.Table+` WHERE user_id <> ? ORDER BY user_id`), AppTokenKey)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var userID string
+		if err := rows.Scan(&userID); err != nil {
+			return nil, err
+		}
+		userIDs = append(userIDs, userID)
+	}
+	return userIDs, rows.Err()
+}
+
+// Load returns the token stored for userID, or nil if there is none.
+func (s *SQLTokenStore) Load(userID string) (*oauth2.Token, error) {
+	return s.LoadContext(context.Background(), userID)
+}
+
+// LoadContext is like Load, with a context for the query.
+func (s *SQLTokenStore) LoadContext(ctx context.Context, userID string) (*oauth2.Token, error) {
+	var (
+		stored storedToken
+		expiry sql.NullTime
+	)
+
+	err := s.db.QueryRowContext(ctx, s.query(
+		`SELECT access_token, token_type, refresh_token, expiry, scopes FROM `+s.settings.Table+` WHERE user_id = ?`),
+		userID,
+	).Scan(&stored.AccessToken, &stored.TokenType, &stored.RefreshToken, &expiry, &stored.Scope)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if expiry.Valid {
+		stored.Expiry = expiry.Time
+	}
+	return stored.token(), nil
+}
+
+// Save stores token for userID, replacing any previous token, and records the
+// current time as its last refresh.
+func (s *SQLTokenStore) Save(userID string, token *oauth2.Token) error {
+	return s.SaveContext(context.Background(), userID, token)
+}
+
+// SaveContext is like Save, with a context for the statement.
+func (s *SQLTokenStore) SaveContext(ctx context.Context, userID string, token *oauth2.Token) error {
+	stored := newStoredToken(token)
+
+	var expiry sql.NullTime
+	if !stored.Expiry.IsZero() {
+		expiry = sql.NullTime{Time: stored.Expiry.UTC(), Valid: true}
+	}
+
+	_, err := s.db.ExecContext(ctx, s.query(
+		`INSERT INTO `+s.settings.Table+` (user_id, access_token, token_type, refresh_token, expiry, scopes, last_refresh)
+		VALUES (?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT (user_id) DO UPDATE SET
+			access_token = excluded.access_token,
+			token_type = excluded.token_type,
+			refresh_token = excluded.refresh_token,
+			expiry = excluded.expiry,
+			scopes = excluded.scopes,
+			last_refresh = excluded.last_refresh`),
+		userID, stored.AccessToken, stored.TokenType, stored.RefreshToken, expiry, stored.Scope, time.Now().UTC(),
+	)
+	return err
+}
+
+// NeedsReauthorization returns the users that have to go through the
+// authorization flow again: those whose token has no refresh token and
+// expires before t, and those that were not granted all of scopes. The
+// application token, which is obtained again without the user, is left out.
+func (s *SQLTokenStore) NeedsReauthorization(ctx context.Context, t time.Time, scopes ...string) (userIDs []string, err error) {
+	rows, err := s.db.QueryContext(ctx, s.query(`SELECT user_id, refresh_token, expiry, scopes FROM `+s.settings.Table+` WHERE user_id <> ?`), AppTokenKey)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var (
+			userID, refreshToken, granted string
+			expiry                        sql.NullTime
+		)
+		if err := rows.Scan(&userID, &refreshToken, &expiry, &granted); err != nil {
+			return nil, err
+		}
+
+		expiring := refreshToken == "" && expiry.Valid && expiry.Time.Before(t)
+		if expiring || !hasScopes(granted, scopes) {
+			userIDs = append(userIDs, userID)
+		}
+	}
+	return userIDs, rows.Err()
+}
+
+// hasScopes reports whether the space separated granted scopes include all of
+// scopes.
+func hasScopes(granted string, scopes []string) bool {
+	fields := strings.Fields(granted)
+	for _, scope := range scopes {
+		found := false
+		for _, field := range fields {
+			if field == scope {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// query rewrites the ? placeholders of query for the store's dialect.
+func (s *SQLTokenStore) query(query string) string {
+	if s.settings.Dialect != Postgres {
+		return query
+	}
+
+	var (
+		b strings.Builder
+		n int
+	)
+	for _, r := range query {
+		if r == '?' {
+			n++
+			b.WriteString("$" + strconv.Itoa(n))
+			continue
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
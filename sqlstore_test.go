@@ -0,0 +1,137 @@
+package itembase
+
+import (
+	"context"
+	"database/sql"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"golang.org/x/oauth2"
+	_ "modernc.org/sqlite"
+)
+
+const sqliteDriver = "sqlite"
+
+func newTestSQLTokenStore(t *testing.T) *SQLTokenStore {
+	t.Helper()
+
+	db, err := sql.Open(sqliteDriver, filepath.Join(t.TempDir(), "tokens.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	store, err := NewSQLTokenStore(db, SQLTokenStoreSettings{Dialect: SQLite})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// migrating twice must be harmless
+	for i := 0; i < 2; i++ {
+		if err := store.Migrate(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return store
+}
+
+func TestSQLTokenStore(t *testing.T) {
+	store := newTestSQLTokenStore(t)
+
+	if token, err := store.Load("missing"); token != nil || err != nil {
+		t.Fatalf("Load(missing) = %v, %v, want nil, nil", token, err)
+	}
+
+	expiry := time.Now().Add(time.Hour).Truncate(time.Second)
+	token := (&oauth2.Token{AccessToken: "a1", TokenType: "Bearer", RefreshToken: "r1", Expiry: expiry}).
+		WithExtra(map[string]interface{}{"scope": "user.minimal connection.product"})
+	if err := store.Save("u1", token); err != nil {
+		t.Fatal(err)
+	}
+
+	loaded, err := store.Load("u1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if loaded.AccessToken != "a1" || loaded.RefreshToken != "r1" || !loaded.Expiry.Equal(expiry) {
+		t.Errorf("Load(u1) = %+v", loaded)
+	}
+	if scope := grantedScope(loaded); scope != "user.minimal connection.product" {
+		t.Errorf("scope = %q", scope)
+	}
+
+	// upsert, without expiry
+	if err := store.Save("u1", &oauth2.Token{AccessToken: "a2", RefreshToken: "r2"}); err != nil {
+		t.Fatal(err)
+	}
+	loaded, err = store.Load("u1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if loaded.AccessToken != "a2" || loaded.RefreshToken != "r2" || !loaded.Expiry.IsZero() {
+		t.Errorf("Load(u1) after upsert = %+v", loaded)
+	}
+
+	for _, userID := range []string{"u2", AppTokenKey} {
+		if err := store.Save(userID, &oauth2.Token{AccessToken: "b1"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if userIDs, err := store.List(); err != nil || !reflect.DeepEqual(userIDs, []string{"u1", "u2"}) {
+		t.Errorf("List() = %v, %v", userIDs, err)
+	}
+
+	if err := store.Delete("u1"); err != nil {
+		t.Fatal(err)
+	}
+	if token, err := store.Load("u1"); token != nil || err != nil {
+		t.Errorf("Load(u1) after Delete = %v, %v", token, err)
+	}
+}
+
+func TestSQLTokenStoreNeedsReauthorization(t *testing.T) {
+	store := newTestSQLTokenStore(t)
+	now := time.Now()
+
+	tokens := map[string]*oauth2.Token{
+		// refreshable
+		"refreshable": (&oauth2.Token{AccessToken: "a", RefreshToken: "r", Expiry: now.Add(-time.Hour)}).
+			WithExtra(map[string]interface{}{"scope": "connection.product"}),
+		// expiring without refresh token
+		"expiring": (&oauth2.Token{AccessToken: "a", Expiry: now.Add(time.Minute)}).
+			WithExtra(map[string]interface{}{"scope": "connection.product"}),
+		// valid long enough, but missing the scope
+		"unscoped": &oauth2.Token{AccessToken: "a", Expiry: now.Add(time.Hour)},
+	}
+	// the application token has no refresh token, but is not a user's
+	tokens[AppTokenKey] = &oauth2.Token{AccessToken: "a", Expiry: now.Add(time.Minute)}
+
+	for userID, token := range tokens {
+		if err := store.Save(userID, token); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	userIDs, err := store.NeedsReauthorization(context.Background(), now.Add(10*time.Minute), "connection.product")
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := make(map[string]bool)
+	for _, userID := range userIDs {
+		got[userID] = true
+	}
+	if want := map[string]bool{"expiring": true, "unscoped": true}; !reflect.DeepEqual(got, want) {
+		t.Errorf("NeedsReauthorization() = %v, want %v", userIDs, want)
+	}
+}
+
+func TestSQLTokenStorePostgresPlaceholders(t *testing.T) {
+	store := &SQLTokenStore{settings: SQLTokenStoreSettings{Dialect: Postgres, Table: "itembase_tokens"}}
+
+	got := store.query(`UPDATE t SET a = ?, b = ? WHERE c = ?`)
+	if want := `UPDATE t SET a = $1, b = $2 WHERE c = $3`; got != want {
+		t.Errorf("query() = %q, want %q", got, want)
+	}
+}
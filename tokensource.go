@@ -0,0 +1,66 @@
+package itembase
+
+import (
+	"fmt"
+	"sync"
+
+	"golang.org/x/oauth2"
+)
+
+// savingTokenSource passes on the tokens of another TokenSource and saves
+// every token that differs from the previous one.
+type savingTokenSource struct {
+	source oauth2.TokenSource
+	userID string
+	saver  TokenSaver
+
+	mu   sync.Mutex
+	last *oauth2.Token
+}
+
+// NewSavingTokenSource returns a TokenSource returning the tokens of source,
+// which calls saver for userID whenever a token differs from the previous
+// one, such as after oauth2 refreshed an expired access token or itembase
+// rotated the refresh token. The first token is compared to token, the one
+// source started from. Tokens returned without a scope keep the scope of the
+// previous token. If saving fails, Token returns an error wrapping
+// ErrTokenSave and tries to save again on the next call.
+func NewSavingTokenSource(source oauth2.TokenSource, userID string, token *oauth2.Token, saver TokenSaver) oauth2.TokenSource {
+	return &savingTokenSource{source: source, userID: userID, saver: saver, last: token}
+}
+
+func (s *savingTokenSource) Token() (*oauth2.Token, error) {
+	token, err := s.source.Token()
+	if err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// the last token carries the scope it was saved with
+	if s.last != nil && sameToken(s.last, token) {
+		return s.last, nil
+	}
+
+	// refresh responses may omit the scope, which is still the one
+	// granted with the previous token
+	if s.last != nil && grantedScope(token) == "" && grantedScope(s.last) != "" {
+		token = token.WithExtra(map[string]interface{}{"scope": grantedScope(s.last)})
+	}
+
+	if s.saver != nil {
+		if err := s.saver(s.userID, token); err != nil {
+			return nil, fmt.Errorf("%w: %w", ErrTokenSave, err)
+		}
+	}
+	s.last = token
+
+	return token, nil
+}
+
+func sameToken(a, b *oauth2.Token) bool {
+	return a.AccessToken == b.AccessToken &&
+		a.RefreshToken == b.RefreshToken &&
+		a.Expiry.Equal(b.Expiry)
+}
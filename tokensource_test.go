@@ -0,0 +1,89 @@
+package itembase_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"golang.org/x/oauth2"
+	"gopkg.in/saasbuilders/itembase.v0"
+	"gopkg.in/saasbuilders/itembase.v0/itembasetest"
+)
+
+func TestExpiredTokenRefreshedAndSaved(t *testing.T) {
+	server := itembasetest.NewServer()
+	t.Cleanup(server.Close)
+	if err := server.Seed(itembasetest.Fixture{
+		User:     itembase.User{UUID: testUserID},
+		Products: []itembase.Product{{ID: "p"}},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	expired, err := server.Token(testUserID, "connection.product")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expired.Expiry = time.Now().Add(-time.Minute)
+	store := newMemoryTokens()
+	store.Handler().TokenSaver(testUserID, expired)
+	seeded := store.saved()
+
+	config := server.Config()
+	config.TokenHandler = store.Handler()
+	storeRef, err := itembase.New(config, nil).ForUser(context.Background(), testUserID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		if _, err := storeRef.Products().Get(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if saves := store.saved() - seeded; saves != 1 {
+		t.Fatalf("%d tokens saved, want the refreshed one only", saves)
+	}
+	saved := store.get(testUserID)
+	if saved.AccessToken == expired.AccessToken || saved.RefreshToken == expired.RefreshToken || !saved.Valid() {
+		t.Errorf("saved token %+v, want a fresh one", saved)
+	}
+	if scope := saved.Extra("scope"); scope != "connection.product" {
+		t.Errorf("saved scope = %v, want connection.product", scope)
+	}
+}
+
+func TestSavingTokenSource(t *testing.T) {
+	previous := (&oauth2.Token{AccessToken: "a", RefreshToken: "r"}).
+		WithExtra(map[string]interface{}{"scope": "connection.product"})
+	// refresh responses may omit the scope
+	fresh := &oauth2.Token{AccessToken: "b", RefreshToken: "s"}
+
+	var saved []*oauth2.Token
+	fail := true
+	source := itembase.NewSavingTokenSource(oauth2.StaticTokenSource(fresh), testUserID, previous, func(userID string, token *oauth2.Token) error {
+		if fail {
+			fail = false
+			return errors.New("database down")
+		}
+		saved = append(saved, token)
+		return nil
+	})
+
+	if _, err := source.Token(); !errors.Is(err, itembase.ErrTokenSave) {
+		t.Fatalf("err = %v, want ErrTokenSave", err)
+	}
+	for i := 0; i < 2; i++ {
+		token, err := source.Token()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if scope := token.Extra("scope"); scope != "connection.product" {
+			t.Errorf("scope = %v, want the previous one", scope)
+		}
+	}
+	if len(saved) != 1 || saved[0].AccessToken != "b" {
+		t.Errorf("saved %v, want the fresh token once", saved)
+	}
+}